@@ -90,12 +90,14 @@ Validate Proxmox configuration
 {{- $secret := .Values.proxmox.secret | default dict }}
 {{- $hasHostUrls := $secret.hostUrls }}
 {{- $hasUrl := $secret.url }}
-{{- if and (not $hasHostUrls) (not $hasUrl) }}
-{{- fail "Proxmox URL is required when proxmox.secret.create=true. Set proxmox.secret.hostUrls or proxmox.secret.url in values.yaml" }}
+{{- $hasHosts := $secret.hosts }}
+{{- $hasClusters := $secret.clusters }}
+{{- if and (not $hasHostUrls) (not $hasUrl) (not $hasHosts) (not $hasClusters) }}
+{{- fail "Proxmox URL is required when proxmox.secret.create=true. Set proxmox.secret.hostUrls, proxmox.secret.url, proxmox.secret.hosts or proxmox.secret.clusters in values.yaml" }}
 {{- end }}
 {{- $hasToken := and $secret.tokenId $secret.secret }}
 {{- $hasPassword := and $secret.username $secret.password }}
-{{- if not (or $hasToken $hasPassword) }}
+{{- if and (or $hasHostUrls $hasUrl) (not (or $hasToken $hasPassword)) }}
 {{- fail "Proxmox authentication is required. Set either tokenId/secret or username/password in values.yaml" }}
 {{- end }}
 {{- else }}
@@ -30,6 +30,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/config"
@@ -52,6 +53,13 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var configPath string
+	var uuidMatchMode string
+	var matchStrategies string
+	var vmNameTemplate string
+	var clusterName string
+	var nameCollisionPolicy string
+	var onNodeDelete string
+	var dryRun bool
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metrics endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -61,6 +69,33 @@ func main() {
 	flag.BoolVar(&secureMetrics, "metrics-secure", false,
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.StringVar(&configPath, "config-path", "", "The path for the config file to read")
+	flag.StringVar(&uuidMatchMode, "uuid-match-mode", string(controller.UUIDMatchExact),
+		"How node SystemUUIDs are matched to VMs: \"exact\", or \"mixed-endian\" to also try the UUID "+
+			"with the byte order of its first three groups swapped.")
+	flag.StringVar(&matchStrategies, "match-strategies", "provider-id,uuid,mac,hostname",
+		"Comma separated strategies tried in order to find the VM of a node: \"provider-id\" resolves a "+
+			"proxmox:// spec.providerID directly, \"uuid\" matches the SystemUUID, "+
+			"\"mac\" the MAC addresses in the node's "+controller.MACAddressesKey+" annotation or label, "+
+			"\"hostname\" the hostname of LXC containers, "+
+			"\"guest-agent\" the hostname and IPs reported by the QEMU guest agent.")
+	flag.StringVar(&vmNameTemplate, "vm-name-template", controller.DefaultNameTemplate,
+		"Go template of the VM name of a node. It can use .NodeName, .ShortName (the node name without domain), "+
+			".ClusterName and .Labels, and the functions lower, upper, replace, trimPrefix and trimSuffix. "+
+			"Names are sanitized to valid Proxmox names of at most 63 characters. LXC containers, whose name "+
+			"is their hostname, are only renamed when the name equals the node name.")
+	flag.StringVar(&clusterName, "cluster-name", "", "The name of the Kubernetes cluster, available to "+
+		"--vm-name-template as .ClusterName.")
+	flag.StringVar(&nameCollisionPolicy, "name-collision-policy", string(controller.CollisionRefuse),
+		"What to do when the VM name is used by another VM in the same Proxmox cluster: \"refuse\" to leave "+
+			"the VM alone and record a warning event, \"suffix\" to name the VM <name>-<vmid> instead, or "+
+			"\"rename-stale\" to rename the other VM to <name>-stale-<vmid> when it is stopped and no other node "+
+			"records it as its VM.")
+	flag.StringVar(&onNodeDelete, "on-node-delete", string(controller.NodeDeletionKeep),
+		"What to do with the VM of a deleted node: \"keep\" its name, \"restore\" the name it had before "+
+			"the controller renamed it, or \"orphan\" it by renaming it to orphaned-<name>. The latter two "+
+			"add a finalizer to nodes.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only log and record events for the VM renames the controller "+
+		"would do, without changing anything in Proxmox or on nodes.")
 
 	opts := zap.Options{
 		Development: true,
@@ -70,6 +105,32 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	matchMode, err := controller.ParseUUIDMatchMode(uuidMatchMode)
+	if err != nil {
+		setupLog.Error(err, "invalid --uuid-match-mode")
+		os.Exit(1)
+	}
+	strategies, err := controller.ParseMatchStrategies(matchStrategies)
+	if err != nil {
+		setupLog.Error(err, "invalid --match-strategies")
+		os.Exit(1)
+	}
+	namePolicy, err := controller.NewNamePolicy(vmNameTemplate, clusterName)
+	if err != nil {
+		setupLog.Error(err, "invalid --vm-name-template")
+		os.Exit(1)
+	}
+	collisionPolicy, err := controller.ParseCollisionPolicy(nameCollisionPolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid --name-collision-policy")
+		os.Exit(1)
+	}
+	deletionPolicy, err := controller.ParseNodeDeletionPolicy(onNodeDelete)
+	if err != nil {
+		setupLog.Error(err, "invalid --on-node-delete")
+		os.Exit(1)
+	}
+
 	// Configure metrics server
 	metricsServerOptions := metricsserver.Options{
 		BindAddress:   metricsAddr,
@@ -111,7 +172,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := mgr.Add(manager.RunnableFunc(proxmoxClient.Start)); err != nil {
+		setupLog.Error(err, "unable to set up Proxmox inventory refresh")
+		os.Exit(1)
+	}
+
 	nodeReconciler := controller.NewNodeReconciler(mgr.GetClient(), mgr.GetScheme(), proxmoxClient)
+	nodeReconciler.Matchers = controller.NewMatchers(proxmoxClient, strategies, matchMode)
+	nodeReconciler.NamePolicy = namePolicy
+	nodeReconciler.CollisionPolicy = collisionPolicy
+	nodeReconciler.NodeDeletionPolicy = deletionPolicy
+	nodeReconciler.DryRun = dryRun
+	if dryRun {
+		setupLog.Info("Running in dry-run mode, VMs will not be renamed")
+	}
+	nodeReconciler.Recorder = mgr.GetEventRecorderFor("proxmox-name-sync-controller")
 	if err = nodeReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Node")
 		os.Exit(1)
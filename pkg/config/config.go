@@ -9,7 +9,7 @@ import (
 	"sigs.k8s.io/yaml"
 )
 
-func LoadProxmoxConfig(configPath string) (*proxmox.ClusterConfig, error) {
+func LoadProxmoxConfig(configPath string) (*proxmox.Config, error) {
 	if configPath == "" {
 		return nil, fmt.Errorf("config path empty")
 	}
@@ -26,22 +26,90 @@ func LoadProxmoxConfig(configPath string) (*proxmox.ClusterConfig, error) {
 	return cfg, err
 }
 
-func validateConfig(config proxmox.ClusterConfig) error {
-	if len(config.HostURLs) == 0 {
+func validateConfig(config proxmox.Config) error {
+	clusters := config.EffectiveClusters()
+	if len(clusters) == 0 {
 		return fmt.Errorf("at least one Proxmox URL must be provided")
 	}
 
-	hasTokenAuth := config.TokenID != "" && config.Secret != ""
-	hasPasswordAuth := config.Username != "" && config.Password != ""
+	names := make(map[string]bool, len(clusters))
+	for _, cluster := range clusters {
+		if cluster.Name == "" {
+			return fmt.Errorf("every entry in clusters must have a name")
+		}
+		if names[cluster.Name] {
+			return fmt.Errorf("duplicate Proxmox cluster name %q", cluster.Name)
+		}
+		names[cluster.Name] = true
 
-	if !hasTokenAuth && !hasPasswordAuth {
-		return fmt.Errorf("authentication credentials are required (token or username/password)")
+		if err := validateClusterConfig(cluster); err != nil {
+			return fmt.Errorf("invalid Proxmox cluster %q: %w", cluster.Name, err)
+		}
 	}
 
 	return nil
 }
 
-func loadFromYAML(filePath string) (*proxmox.ClusterConfig, error) {
+func validateClusterConfig(config proxmox.ClusterConfig) error {
+	hosts := config.EffectiveHosts()
+	if len(hosts) == 0 {
+		return fmt.Errorf("at least one Proxmox URL must be provided")
+	}
+
+	for i, host := range hosts {
+		if err := proxmox.ValidateHost(host); err != nil {
+			return fmt.Errorf("invalid Proxmox host %d (%s): %w", i, host.URL, err)
+		}
+	}
+
+	if config.Inventory.RefreshInterval.Duration < 0 ||
+		config.Inventory.MinRefreshInterval.Duration < 0 ||
+		config.Inventory.ConfigCacheTTL.Duration < 0 {
+		return fmt.Errorf("inventory intervals must not be negative")
+	}
+
+	if config.Inventory.RefreshInterval.Duration > 0 &&
+		config.Inventory.MinRefreshInterval.Duration > config.Inventory.RefreshInterval.Duration {
+		return fmt.Errorf("inventory minRefreshInterval must not exceed refreshInterval")
+	}
+
+	if !config.SelectionPolicy.Valid() {
+		return fmt.Errorf("unknown selectionPolicy %q", config.SelectionPolicy)
+	}
+
+	if config.HealthCheck.Interval.Duration < 0 ||
+		config.HealthCheck.Timeout.Duration < 0 ||
+		config.HealthCheck.Backoff.Duration < 0 ||
+		config.HealthCheck.FailureThreshold < 0 {
+		return fmt.Errorf("healthCheck settings must not be negative")
+	}
+
+	if config.Concurrency.Global < 0 || config.Concurrency.PerNode < 0 {
+		return fmt.Errorf("concurrency limits must not be negative")
+	}
+
+	if config.RateLimit.QPS < 0 || config.RateLimit.Burst < 0 {
+		return fmt.Errorf("rateLimit qps and burst must not be negative")
+	}
+
+	if config.Retry.MaxAttempts < 0 ||
+		config.Retry.InitialBackoff.Duration < 0 ||
+		config.Retry.MaxBackoff.Duration < 0 {
+		return fmt.Errorf("retry settings must not be negative")
+	}
+	if config.Retry.InitialBackoff.Duration > 0 && config.Retry.MaxBackoff.Duration > 0 &&
+		config.Retry.InitialBackoff.Duration > config.Retry.MaxBackoff.Duration {
+		return fmt.Errorf("retry initialBackoff must not exceed maxBackoff")
+	}
+
+	if config.GuestAgent.Timeout.Duration < 0 || config.GuestAgent.CacheTTL.Duration < 0 {
+		return fmt.Errorf("guestAgent timeout and cacheTtl must not be negative")
+	}
+
+	return nil
+}
+
+func loadFromYAML(filePath string) (*proxmox.Config, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open YAML config at %s: %w", filePath, err)
@@ -56,7 +124,7 @@ func loadFromYAML(filePath string) (*proxmox.ClusterConfig, error) {
 		return nil, fmt.Errorf("failed to close config file: %w", err)
 	}
 
-	var cfg proxmox.ClusterConfig
+	var cfg proxmox.Config
 	if err := yaml.Unmarshal(b, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse YAML config: %w", err)
 	}
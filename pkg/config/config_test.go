@@ -3,17 +3,19 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/proxmox"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
 )
 
 func TestLoadConfig(t *testing.T) {
 	tests := []struct {
 		name      string
 		rawConfig string
-		expected  *proxmox.ClusterConfig
+		expected  *proxmox.Config
 	}{
 		{
 			name: "read config successfully",
@@ -24,14 +26,87 @@ password: test
 insecure: true
 tokenId: test@pve!test
 secret: 1234-23-12323-45235-353`,
-			expected: &proxmox.ClusterConfig{
+			expected: &proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
 				HostURLs: []string{"https://192.168.1.111", "https://192.168.1.112"},
 				Username: "test",
 				Password: "test",
 				TokenID:  "test@pve!test",
 				Secret:   "1234-23-12323-45235-353",
 				Insecure: true,
-			},
+			}},
+		},
+		{
+			name: "read inventory intervals",
+			rawConfig: `---
+hostUrls: ["https://192.168.1.111"]
+tokenId: test@pve!test
+secret: 1234-23-12323-45235-353
+inventory:
+  refreshInterval: 10m
+  minRefreshInterval: 45s`,
+			expected: &proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				HostURLs: []string{"https://192.168.1.111"},
+				TokenID:  "test@pve!test",
+				Secret:   "1234-23-12323-45235-353",
+				Inventory: proxmox.InventoryConfig{
+					RefreshInterval:    proxmox.Duration{Duration: 10 * time.Minute},
+					MinRefreshInterval: proxmox.Duration{Duration: 45 * time.Second},
+				},
+			}},
+		},
+		{
+			name: "read per-host configuration",
+			rawConfig: `---
+hostUrls: ["https://192.168.1.113"]
+tokenId: test@pve!test
+secret: 1234-23-12323-45235-353
+hosts:
+  - url: https://192.168.1.111
+    insecure: false
+    serverName: pve1.example.com
+    timeout: 10s
+    priority: 1
+  - url: https://192.168.1.112
+    username: root@pam
+    password: test
+    priority: 2`,
+			expected: &proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				HostURLs: []string{"https://192.168.1.113"},
+				TokenID:  "test@pve!test",
+				Secret:   "1234-23-12323-45235-353",
+				Hosts: []proxmox.HostConfig{
+					{
+						URL:        "https://192.168.1.111",
+						Insecure:   ptr.To(false),
+						ServerName: "pve1.example.com",
+						Timeout:    proxmox.Duration{Duration: 10 * time.Second},
+						Priority:   1,
+					},
+					{
+						URL:      "https://192.168.1.112",
+						Username: "root@pam",
+						Password: "test",
+						Priority: 2,
+					},
+				},
+			}},
+		},
+		{
+			name: "read multiple clusters",
+			rawConfig: `---
+clusters:
+  - name: east
+    hostUrls: ["https://192.168.1.111"]
+    tokenId: east@pve!test
+    secret: east-secret
+  - name: west
+    hostUrls: ["https://192.168.2.111"]
+    tokenId: west@pve!test
+    secret: west-secret`,
+			expected: &proxmox.Config{Clusters: []proxmox.ClusterConfig{
+				{Name: "east", HostURLs: []string{"https://192.168.1.111"}, TokenID: "east@pve!test", Secret: "east-secret"},
+				{Name: "west", HostURLs: []string{"https://192.168.2.111"}, TokenID: "west@pve!test", Secret: "west-secret"},
+			}},
 		},
 	}
 
@@ -51,3 +126,161 @@ secret: 1234-23-12323-45235-353`,
 		})
 	}
 }
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  proxmox.Config
+		wantErr string
+	}{
+		{
+			name: "host inherits cluster credentials",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				Hosts:   []proxmox.HostConfig{{URL: "https://pve1:8006"}},
+				TokenID: "test@pve!test",
+				Secret:  "secret",
+			}},
+		},
+		{
+			name:    "no hosts",
+			config:  proxmox.Config{ClusterConfig: proxmox.ClusterConfig{TokenID: "test@pve!test", Secret: "secret"}},
+			wantErr: "at least one Proxmox URL must be provided",
+		},
+		{
+			name:    "host without credentials",
+			config:  proxmox.Config{ClusterConfig: proxmox.ClusterConfig{Hosts: []proxmox.HostConfig{{URL: "https://pve1:8006"}}}},
+			wantErr: "authentication credentials are required",
+		},
+		{
+			name: "host without scheme",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				Hosts:   []proxmox.HostConfig{{URL: "pve1:8006"}},
+				TokenID: "test@pve!test",
+				Secret:  "secret",
+			}},
+			wantErr: "must use http or https",
+		},
+		{
+			name: "malformed CA bundle",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				Hosts:   []proxmox.HostConfig{{URL: "https://pve1:8006", CABundle: "not a certificate"}},
+				TokenID: "test@pve!test",
+				Secret:  "secret",
+			}},
+			wantErr: "CA bundle does not contain any valid PEM certificate",
+		},
+		{
+			name: "unreadable CA bundle file",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				Hosts:   []proxmox.HostConfig{{URL: "https://pve1:8006", CABundleFile: "/nonexistent/ca.pem"}},
+				TokenID: "test@pve!test",
+				Secret:  "secret",
+			}},
+			wantErr: "failed to read CA bundle file",
+		},
+		{
+			name: "malformed fingerprint",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				Hosts:   []proxmox.HostConfig{{URL: "https://pve1:8006", Fingerprint: "not-a-fingerprint"}},
+				TokenID: "test@pve!test",
+				Secret:  "secret",
+			}},
+			wantErr: "is not a SHA-256 fingerprint",
+		},
+		{
+			name: "malformed TOTP secret",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				HostURLs:   []string{"https://pve1:8006"},
+				Username:   "root@pam",
+				Password:   "test",
+				TOTPSecret: "not base32!",
+			}},
+			wantErr: "invalid TOTP secret",
+		},
+		{
+			name: "TOTP secret with API token",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				HostURLs:   []string{"https://pve1:8006"},
+				TokenID:    "test@pve!test",
+				Secret:     "secret",
+				TOTPSecret: "GEZDGNBVGY3TQOJQ",
+			}},
+			wantErr: "totpSecret requires username/password authentication",
+		},
+		{
+			name: "negative host rate limit",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				Hosts:   []proxmox.HostConfig{{URL: "https://pve1:8006", RateLimit: proxmox.RateLimitConfig{QPS: -1}}},
+				TokenID: "test@pve!test",
+				Secret:  "secret",
+			}},
+			wantErr: "rateLimit qps and burst must not be negative",
+		},
+		{
+			name: "retry backoff out of order",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				HostURLs: []string{"https://pve1:8006"},
+				TokenID:  "test@pve!test",
+				Secret:   "secret",
+				Retry: proxmox.RetryConfig{
+					InitialBackoff: proxmox.Duration{Duration: time.Minute},
+					MaxBackoff:     proxmox.Duration{Duration: time.Second},
+				},
+			}},
+			wantErr: "retry initialBackoff must not exceed maxBackoff",
+		},
+		{
+			name: "negative guest agent timeout",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				HostURLs:   []string{"https://pve1:8006"},
+				TokenID:    "test@pve!test",
+				Secret:     "secret",
+				GuestAgent: proxmox.GuestAgentConfig{Timeout: proxmox.Duration{Duration: -time.Second}},
+			}},
+			wantErr: "guestAgent timeout and cacheTtl must not be negative",
+		},
+		{
+			name: "unknown selection policy",
+			config: proxmox.Config{ClusterConfig: proxmox.ClusterConfig{
+				HostURLs:        []string{"https://pve1:8006"},
+				TokenID:         "test@pve!test",
+				Secret:          "secret",
+				SelectionPolicy: "random",
+			}},
+			wantErr: `unknown selectionPolicy "random"`,
+		},
+		{
+			name: "named clusters",
+			config: proxmox.Config{Clusters: []proxmox.ClusterConfig{
+				{Name: "east", HostURLs: []string{"https://pve-east:8006"}, TokenID: "test@pve!test", Secret: "secret"},
+				{Name: "west", HostURLs: []string{"https://pve-west:8006"}, TokenID: "test@pve!test", Secret: "secret"},
+			}},
+		},
+		{
+			name: "cluster without name",
+			config: proxmox.Config{Clusters: []proxmox.ClusterConfig{
+				{HostURLs: []string{"https://pve-east:8006"}, TokenID: "test@pve!test", Secret: "secret"},
+			}},
+			wantErr: "every entry in clusters must have a name",
+		},
+		{
+			name: "duplicate cluster names",
+			config: proxmox.Config{Clusters: []proxmox.ClusterConfig{
+				{Name: "east", HostURLs: []string{"https://pve-1:8006"}, TokenID: "test@pve!test", Secret: "secret"},
+				{Name: "east", HostURLs: []string{"https://pve-2:8006"}, TokenID: "test@pve!test", Secret: "secret"},
+			}},
+			wantErr: `duplicate Proxmox cluster name "east"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig(tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/proxmox"
+)
+
+// CollisionPolicy decides what happens when the desired name of a node's VM
+// is already used by another VM or container in the same Proxmox cluster.
+type CollisionPolicy string
+
+const (
+	// CollisionRefuse leaves the VM alone and records a warning event.
+	CollisionRefuse CollisionPolicy = "refuse"
+	// CollisionSuffix names the VM "<name>-<vmid>" instead.
+	CollisionSuffix CollisionPolicy = "suffix"
+	// CollisionRenameStale renames the other VMs to "<name>-stale-<vmid>" when
+	// they are stopped and not recorded on another node, assuming they are
+	// left over from a previous incarnation of the node. Otherwise it refuses.
+	CollisionRenameStale CollisionPolicy = "rename-stale"
+)
+
+// vmStatusStopped is the Proxmox status of a VM that is not running.
+const vmStatusStopped = "stopped"
+
+// EventReasonNameCollision is recorded when the desired VM name is taken.
+const EventReasonNameCollision = "VMNameCollision"
+
+// ParseCollisionPolicy validates a --name-collision-policy value.
+func ParseCollisionPolicy(policy string) (CollisionPolicy, error) {
+	switch CollisionPolicy(policy) {
+	case CollisionRefuse, CollisionSuffix, CollisionRenameStale:
+		return CollisionPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown name collision policy %q, must be %q, %q or %q",
+			policy, CollisionRefuse, CollisionSuffix, CollisionRenameStale)
+	}
+}
+
+// resolveNameCollision checks name against the other VMs of vm's cluster and
+// applies the collision policy. It returns the name to give vm, or false when
+// vm must not be renamed.
+func (r *NodeReconciler) resolveNameCollision(ctx context.Context, node *corev1.Node, vm *proxmox.VM, name string) (string, bool, error) {
+	logger := log.FromContext(ctx).WithValues("node", node.Name, "vmid", vm.ID, "name", name)
+
+	conflicts, err := r.conflictingVMs(ctx, vm, name)
+	if err != nil {
+		return "", false, err
+	}
+	if len(conflicts) == 0 {
+		return name, true, nil
+	}
+
+	switch r.CollisionPolicy {
+	case CollisionSuffix:
+		suffixed, err := SanitizeVMName(fmt.Sprintf("%s-%d", name, vm.ID))
+		if err != nil {
+			return "", false, err
+		}
+		if vm.Name == suffixed {
+			return suffixed, true, nil
+		}
+
+		taken, err := r.conflictingVMs(ctx, vm, suffixed)
+		if err != nil {
+			return "", false, err
+		}
+		if len(taken) > 0 {
+			r.refuseName(ctx, node, vm, suffixed, taken)
+			return "", false, nil
+		}
+
+		logger.Info("VM name is taken, using a suffixed name", "suffixedName", suffixed, "conflictingVMIDs", vmIDs(conflicts))
+		r.event(node, corev1.EventTypeNormal, EventReasonNameCollision,
+			fmt.Sprintf("VM name %q is used by VM %v, naming VM %d %q instead", name, vmIDs(conflicts), vm.ID, suffixed))
+		return suffixed, true, nil
+
+	case CollisionRenameStale:
+		live, err := r.liveVMs(ctx, node, conflicts)
+		if err != nil {
+			return "", false, err
+		}
+		if len(live) > 0 {
+			r.refuseName(ctx, node, vm, name, live)
+			return "", false, nil
+		}
+
+		for _, conflict := range conflicts {
+			staleName, err := SanitizeVMName(fmt.Sprintf("%s-stale-%d", name, conflict.ID))
+			if err != nil {
+				return "", false, err
+			}
+
+			logger.Info("Renaming stale VM using the VM name", "staleVMID", conflict.ID, "staleNode", conflict.Node, "staleName", staleName)
+			if err := r.updateVMName(ctx, node, &conflict, staleName); err != nil {
+				return "", false, fmt.Errorf("failed to rename stale VM %d: %w", conflict.ID, err)
+			}
+			if r.DryRun {
+				continue
+			}
+			r.event(node, corev1.EventTypeNormal, EventReasonNameCollision,
+				fmt.Sprintf("Renamed stale VM %d from %q to %q", conflict.ID, name, staleName))
+		}
+		return name, true, nil
+
+	default:
+		r.refuseName(ctx, node, vm, name, conflicts)
+		return "", false, nil
+	}
+}
+
+// conflictingVMs returns the VMs in vm's cluster other than vm named name. A
+// partial inventory is logged and the VMs that could be listed are checked.
+func (r *NodeReconciler) conflictingVMs(ctx context.Context, vm *proxmox.VM, name string) ([]proxmox.VM, error) {
+	named, err := r.ProxmoxClient.GetVMsByName(ctx, vm.Cluster, name)
+	var partial *proxmox.PartialResultError
+	if errors.As(err, &partial) {
+		log.FromContext(ctx).Info("Some Proxmox nodes could not be listed, checking the VM name against the others",
+			"vmid", vm.ID, "name", name, "failedNodes", partial.FailedNodes())
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []proxmox.VM
+	for _, other := range named {
+		if other.ID != vm.ID {
+			conflicts = append(conflicts, other)
+		}
+	}
+
+	return conflicts, nil
+}
+
+// liveVMs returns the VMs of conflicts that must not be renamed as stale,
+// because they are not stopped or another node records them as its VM.
+func (r *NodeReconciler) liveVMs(ctx context.Context, node *corev1.Node, conflicts []proxmox.VM) ([]proxmox.VM, error) {
+	var nodes corev1.NodeList
+	if err := r.List(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	tracked := make(map[string]bool)
+	for _, other := range nodes.Items {
+		if other.Name == node.Name {
+			continue
+		}
+		tracked[other.Annotations[VMAnnotation]] = true
+		tracked[other.Spec.ProviderID] = true
+	}
+
+	var live []proxmox.VM
+	for _, conflict := range conflicts {
+		ref := proxmox.ProviderID{Cluster: conflict.Cluster, VMID: conflict.ID}.String()
+		if conflict.Status != vmStatusStopped || tracked[ref] {
+			live = append(live, conflict)
+		}
+	}
+
+	return live, nil
+}
+
+func (r *NodeReconciler) refuseName(ctx context.Context, node *corev1.Node, vm *proxmox.VM, name string, conflicts []proxmox.VM) {
+	log.FromContext(ctx).Info("VM name is used by another VM, not renaming",
+		"node", node.Name, "vmid", vm.ID, "name", name, "conflictingVMIDs", vmIDs(conflicts))
+	r.event(node, corev1.EventTypeWarning, EventReasonNameCollision,
+		fmt.Sprintf("Not renaming VM %d to %q, the name is used by VM %v", vm.ID, name, vmIDs(conflicts)))
+}
+
+func vmIDs(vms []proxmox.VM) []int {
+	ids := make([]int, 0, len(vms))
+	for _, vm := range vms {
+		ids = append(ids, vm.ID)
+	}
+
+	return ids
+}
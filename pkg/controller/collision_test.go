@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeReconciler_Reconcile_NameCollision(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-01"},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-1"}},
+	}
+	stale := proxmox.VM{ID: 90, Name: "worker-01", Node: "pve-2", Cluster: "east", Status: "stopped"}
+	running := proxmox.VM{ID: 90, Name: "worker-01", Node: "pve-2", Cluster: "east", Status: "running"}
+
+	tests := []struct {
+		name        string
+		policy      CollisionPolicy
+		vmName      string
+		taken       map[string][]proxmox.VM
+		trackedBy   string
+		wantRenames map[int]string
+		wantEvent   string
+	}{
+		{
+			name:        "free name",
+			policy:      CollisionRefuse,
+			vmName:      "vm-100",
+			wantRenames: map[int]string{100: "worker-01"},
+		},
+		{
+			name:      "refuse",
+			policy:    CollisionRefuse,
+			vmName:    "vm-100",
+			taken:     map[string][]proxmox.VM{"worker-01": {stale}},
+			wantEvent: "Warning VMNameCollision",
+		},
+		{
+			name:        "suffix",
+			policy:      CollisionSuffix,
+			vmName:      "vm-100",
+			taken:       map[string][]proxmox.VM{"worker-01": {stale}},
+			wantRenames: map[int]string{100: "worker-01-100"},
+			wantEvent:   "Normal VMNameCollision",
+		},
+		{
+			name:   "already suffixed",
+			policy: CollisionSuffix,
+			vmName: "worker-01-100",
+			taken:  map[string][]proxmox.VM{"worker-01": {stale}},
+		},
+		{
+			name:   "suffixed name taken too",
+			policy: CollisionSuffix,
+			vmName: "vm-100",
+			taken: map[string][]proxmox.VM{
+				"worker-01":     {stale},
+				"worker-01-100": {{ID: 91, Name: "worker-01-100", Node: "pve-2", Cluster: "east"}},
+			},
+			wantEvent: "Warning VMNameCollision",
+		},
+		{
+			name:        "rename stale",
+			policy:      CollisionRenameStale,
+			vmName:      "vm-100",
+			taken:       map[string][]proxmox.VM{"worker-01": {stale}},
+			wantRenames: map[int]string{90: "worker-01-stale-90", 100: "worker-01"},
+			wantEvent:   "Normal VMNameCollision",
+		},
+		{
+			name:      "running VM is not stale",
+			policy:    CollisionRenameStale,
+			vmName:    "vm-100",
+			taken:     map[string][]proxmox.VM{"worker-01": {running}},
+			wantEvent: "Warning VMNameCollision",
+		},
+		{
+			name:      "VM of another node is not stale",
+			policy:    CollisionRenameStale,
+			vmName:    "vm-100",
+			taken:     map[string][]proxmox.VM{"worker-01": {stale}},
+			trackedBy: "worker-02",
+			wantEvent: "Warning VMNameCollision",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			renames := map[int]string{}
+			mock := &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					return &proxmox.VM{ID: 100, Name: tc.vmName, Node: "pve-1", Cluster: "east", UUID: uuid}, nil
+				},
+				GetVMsByNameFn: func(ctx context.Context, cluster string, name string) ([]proxmox.VM, error) {
+					assert.Equal(t, "east", cluster)
+					return tc.taken[name], nil
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+					renames[vm.ID] = newName
+					return nil
+				},
+			}
+
+			objects := []client.Object{node.DeepCopy()}
+			if tc.trackedBy != "" {
+				objects = append(objects, &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+					Name:        tc.trackedBy,
+					Annotations: map[string]string{VMAnnotation: "proxmox://east/90"},
+				}})
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+			recorder := record.NewFakeRecorder(10)
+			r := NewNodeReconciler(c, scheme, mock)
+			r.CollisionPolicy = tc.policy
+			r.Recorder = recorder
+
+			_, err := r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+			require.NoError(t, err)
+			if tc.wantRenames == nil {
+				tc.wantRenames = map[int]string{}
+			}
+			assert.Equal(t, tc.wantRenames, renames)
+
+			if tc.wantEvent == "" {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			require.NotEmpty(t, recorder.Events)
+			assert.Contains(t, <-recorder.Events, tc.wantEvent)
+		})
+	}
+}
+
+func TestParseCollisionPolicy(t *testing.T) {
+	for _, policy := range []string{"refuse", "suffix", "rename-stale"} {
+		got, err := ParseCollisionPolicy(policy)
+		require.NoError(t, err)
+		assert.Equal(t, CollisionPolicy(policy), got)
+	}
+
+	_, err := ParseCollisionPolicy("overwrite")
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeReconciler_Reconcile_DryRun(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-01"},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-1"}},
+	}
+	vmName := "vm-100"
+	mock := &MockProxmoxClient{
+		GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+			return &proxmox.VM{ID: 100, Name: vmName, Node: "pve-1", Cluster: "east", UUID: uuid}, nil
+		},
+		UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, name string) error {
+			t.Errorf("unexpected rename of VM %d to %q", vm.ID, name)
+			return nil
+		},
+		UpdateVMDescriptionFn: func(ctx context.Context, vm *proxmox.VM, description string) error {
+			t.Errorf("unexpected description update of VM %d", vm.ID)
+			return nil
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	recorder := record.NewFakeRecorder(1)
+	r := NewNodeReconciler(c, scheme, mock)
+	r.NodeDeletionPolicy = NodeDeletionRestore
+	r.Recorder = recorder
+	r.DryRun = true
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	_, err := r.Reconcile(t.Context(), req)
+	require.NoError(t, err)
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, `Normal DryRunVMRename Dry run: would rename VM 100 from "vm-100" to "worker-01"`)
+	assert.Equal(t, 1, r.pending.count())
+
+	var got corev1.Node
+	require.NoError(t, c.Get(t.Context(), req.NamespacedName, &got))
+	assert.Empty(t, got.Annotations)
+	assert.Empty(t, got.Finalizers)
+
+	vmName = "worker-01"
+	_, err = r.Reconcile(t.Context(), req)
+	require.NoError(t, err)
+	assert.Equal(t, 0, r.pending.count())
+}
+
+func TestNodeReconciler_Reconcile_DryRunDeletedNode(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:              "worker-01",
+		DeletionTimestamp: &metav1.Time{Time: metav1.Now().Time},
+		Finalizers:        []string{NodeFinalizer},
+		Annotations: map[string]string{
+			OriginalNameAnnotation: "vm-100",
+			VMAnnotation:           "proxmox://east/100",
+		},
+	}}
+	mock := &MockProxmoxClient{
+		GetVMByIDFn: func(ctx context.Context, cluster string, vmid int) (*proxmox.VM, error) {
+			return &proxmox.VM{ID: 100, Name: "worker-01", Cluster: "east", Description: withOriginalName("", "vm-100")}, nil
+		},
+		UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, name string) error {
+			t.Errorf("unexpected rename of VM %d to %q", vm.ID, name)
+			return nil
+		},
+		UpdateVMDescriptionFn: func(ctx context.Context, vm *proxmox.VM, description string) error {
+			t.Errorf("unexpected description update of VM %d", vm.ID)
+			return nil
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	recorder := record.NewFakeRecorder(2)
+	r := NewNodeReconciler(c, scheme, mock)
+	r.NodeDeletionPolicy = NodeDeletionRestore
+	r.Recorder = recorder
+	r.DryRun = true
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	_, err := r.Reconcile(t.Context(), req)
+	require.NoError(t, err)
+	require.Len(t, recorder.Events, 2)
+	assert.Contains(t, <-recorder.Events, `Normal DryRunVMRename Dry run: would rename VM 100 from "worker-01" to "vm-100"`)
+	assert.Contains(t, <-recorder.Events, "Normal DryRunNodeFinalize")
+
+	var got corev1.Node
+	require.NoError(t, c.Get(t.Context(), req.NamespacedName, &got), "the node is not deleted")
+	assert.Contains(t, got.Finalizers, NodeFinalizer)
+}
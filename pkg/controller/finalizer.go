@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/proxmox"
+)
+
+const (
+	// OriginalNameAnnotation records the name the node's VM had before the
+	// controller first renamed it.
+	OriginalNameAnnotation = "proxmox-name-sync-controller/original-vm-name"
+	// VMAnnotation records the node's VM as "proxmox://<cluster>/<vmid>".
+	VMAnnotation = "proxmox-name-sync-controller/vm"
+	// NodeFinalizer delays the deletion of a node until its VM is restored
+	// or orphaned according to the NodeDeletionPolicy.
+	NodeFinalizer = "proxmox-name-sync-controller/vm-name"
+
+	// originalNameMarker starts the line of a VM description that records the
+	// VM's original name, so that it survives the loss of the node.
+	originalNameMarker = "proxmox-name-sync-controller original-name: "
+	// orphanedNamePrefix is prepended to the names of VMs whose node is gone.
+	orphanedNamePrefix = "orphaned-"
+)
+
+// NodeDeletionPolicy decides what happens to the VM of a deleted node.
+type NodeDeletionPolicy string
+
+const (
+	// NodeDeletionKeep leaves the VM name alone and adds no finalizer.
+	NodeDeletionKeep NodeDeletionPolicy = "keep"
+	// NodeDeletionRestore renames the VM back to its original name.
+	NodeDeletionRestore NodeDeletionPolicy = "restore"
+	// NodeDeletionOrphan renames the VM to "orphaned-<name>".
+	NodeDeletionOrphan NodeDeletionPolicy = "orphan"
+)
+
+// ParseNodeDeletionPolicy validates an --on-node-delete value.
+func ParseNodeDeletionPolicy(policy string) (NodeDeletionPolicy, error) {
+	switch NodeDeletionPolicy(policy) {
+	case NodeDeletionKeep, NodeDeletionRestore, NodeDeletionOrphan:
+		return NodeDeletionPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown node deletion policy %q, must be %q, %q or %q",
+			policy, NodeDeletionKeep, NodeDeletionRestore, NodeDeletionOrphan)
+	}
+}
+
+// trackVM records vm and its original name on the node, adds the finalizer
+// and marks the original name in the VM's description. It must run before vm
+// is renamed. Nothing is recorded when VMs are kept on deletion or in dry-run
+// mode.
+func (r *NodeReconciler) trackVM(ctx context.Context, node *corev1.Node, vm *proxmox.VM) error {
+	if r.DryRun || r.NodeDeletionPolicy == NodeDeletionKeep {
+		return nil
+	}
+
+	originalName := node.Annotations[OriginalNameAnnotation]
+	if originalName == "" {
+		originalName, _ = originalNameFromDescription(vm.Description)
+	}
+	if originalName == "" {
+		originalName = vm.Name
+	}
+
+	patch := client.MergeFrom(node.DeepCopy())
+	changed := false
+	if node.Annotations == nil {
+		node.Annotations = make(map[string]string)
+	}
+	if node.Annotations[OriginalNameAnnotation] != originalName {
+		node.Annotations[OriginalNameAnnotation] = originalName
+		changed = true
+	}
+	vmRef := proxmox.ProviderID{Cluster: vm.Cluster, VMID: vm.ID}.String()
+	if node.Annotations[VMAnnotation] != vmRef {
+		node.Annotations[VMAnnotation] = vmRef
+		changed = true
+	}
+	changed = controllerutil.AddFinalizer(node, NodeFinalizer) || changed
+	if changed {
+		if err := r.Patch(ctx, node, patch); err != nil {
+			return fmt.Errorf("failed to record VM on node: %w", err)
+		}
+	}
+
+	if _, ok := originalNameFromDescription(vm.Description); !ok {
+		if err := r.ProxmoxClient.UpdateVMDescription(ctx, vm, withOriginalName(vm.Description, originalName)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// finalizeNode applies the NodeDeletionPolicy to the VM of a deleted node and
+// removes the finalizer. VMs without the original name marker in their
+// description are left alone, as their VM id may have been reused. In dry-run
+// mode the finalizer stays in place, so the node is only deleted once the
+// controller runs without --dry-run or the finalizer is removed by hand.
+func (r *NodeReconciler) finalizeNode(ctx context.Context, node *corev1.Node) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(node, NodeFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	logger := log.FromContext(ctx).WithValues("node", node.Name)
+
+	if r.NodeDeletionPolicy == NodeDeletionRestore || r.NodeDeletionPolicy == NodeDeletionOrphan {
+		vm, err := r.trackedVM(ctx, node)
+		if err != nil && proxmox.KindOf(err) != proxmox.ErrorNotFound {
+			return r.handleProxmoxError(ctx, node, "Failed to find VM of deleted node in Proxmox", err)
+		}
+
+		originalName, tracked := "", false
+		if vm != nil {
+			originalName, tracked = originalNameFromDescription(vm.Description)
+		}
+		if !tracked {
+			logger.Info("VM of deleted node not found or not renamed by the controller, leaving it alone")
+		} else if err := r.releaseVM(ctx, node, vm, originalName); err != nil {
+			return r.handleProxmoxError(ctx, node, "Failed to release VM of deleted node", err, "vmid", vm.ID)
+		}
+	}
+
+	if r.DryRun {
+		logger.Info("Dry run, not removing finalizer of deleted node", "finalizer", NodeFinalizer)
+		r.event(node, corev1.EventTypeNormal, EventReasonDryRunFinalize,
+			fmt.Sprintf("Dry run: would remove finalizer %s from deleted node", NodeFinalizer))
+		return ctrl.Result{RequeueAfter: requeueDuration}, nil
+	}
+
+	patch := client.MergeFrom(node.DeepCopy())
+	controllerutil.RemoveFinalizer(node, NodeFinalizer)
+	if err := r.Patch(ctx, node, patch); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// releaseVM renames the VM of a deleted node as the NodeDeletionPolicy says.
+func (r *NodeReconciler) releaseVM(ctx context.Context, node *corev1.Node, vm *proxmox.VM, originalName string) error {
+	if annotated := node.Annotations[OriginalNameAnnotation]; annotated != "" {
+		originalName = annotated
+	}
+
+	name := originalName
+	if r.NodeDeletionPolicy == NodeDeletionOrphan {
+		name = vm.Name
+		if !strings.HasPrefix(name, orphanedNamePrefix) {
+			var err error
+			if name, err = SanitizeVMName(orphanedNamePrefix + vm.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	if name != vm.Name {
+		log.FromContext(ctx).Info("Renaming VM of deleted node", "node", node.Name, "vmid", vm.ID,
+			"policy", r.NodeDeletionPolicy, "currentVMName", vm.Name, "newVMName", name)
+		if err := r.updateVMName(ctx, node, vm, name); err != nil {
+			return err
+		}
+	}
+	if r.NodeDeletionPolicy == NodeDeletionRestore && !r.DryRun {
+		return r.ProxmoxClient.UpdateVMDescription(ctx, vm, withoutOriginalName(vm.Description))
+	}
+
+	return nil
+}
+
+// trackedVM reads the VM recorded on the node, or matches it again when the
+// node predates the annotation.
+func (r *NodeReconciler) trackedVM(ctx context.Context, node *corev1.Node) (*proxmox.VM, error) {
+	providerID, err := proxmox.ParseProviderID(node.Annotations[VMAnnotation])
+	if err != nil || providerID.UUID != "" {
+		return matchVM(ctx, r.Matchers, node)
+	}
+
+	return r.ProxmoxClient.GetVMByID(ctx, providerID.Cluster, providerID.VMID)
+}
+
+// originalNameFromDescription returns the name recorded by withOriginalName.
+func originalNameFromDescription(description string) (string, bool) {
+	for line := range strings.SplitSeq(description, "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), originalNameMarker); ok {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// withOriginalName appends the original name marker to description.
+func withOriginalName(description, name string) string {
+	description = strings.TrimRight(withoutOriginalName(description), "\n")
+	if description == "" {
+		return originalNameMarker + name
+	}
+
+	return description + "\n" + originalNameMarker + name
+}
+
+// withoutOriginalName removes the original name marker from description.
+func withoutOriginalName(description string) string {
+	var lines []string
+	for line := range strings.SplitSeq(description, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), originalNameMarker) {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
@@ -0,0 +1,257 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOriginalNameMarker(t *testing.T) {
+	description := withOriginalName("web tier\n", "vm-100")
+	assert.Equal(t, "web tier\n"+originalNameMarker+"vm-100", description)
+
+	name, ok := originalNameFromDescription(description)
+	assert.True(t, ok)
+	assert.Equal(t, "vm-100", name)
+
+	assert.Equal(t, "web tier\n"+originalNameMarker+"vm-200", withOriginalName(description, "vm-200"), "the marker is replaced")
+	assert.Equal(t, "web tier", withoutOriginalName(description))
+
+	_, ok = originalNameFromDescription("web tier")
+	assert.False(t, ok)
+}
+
+func TestNodeReconciler_Reconcile_RecordsOriginalName(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-01"},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-1"}},
+	}
+	var description string
+	mock := &MockProxmoxClient{
+		GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+			return &proxmox.VM{ID: 100, Name: "vm-100", Node: "pve-1", Cluster: "east", UUID: uuid, Description: "web tier"}, nil
+		},
+		UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+			return nil
+		},
+		UpdateVMDescriptionFn: func(ctx context.Context, vm *proxmox.VM, newDescription string) error {
+			description = newDescription
+			return nil
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	r := NewNodeReconciler(c, scheme, mock)
+	r.NodeDeletionPolicy = NodeDeletionRestore
+
+	_, err := r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+	require.NoError(t, err)
+
+	var updated corev1.Node
+	require.NoError(t, c.Get(t.Context(), types.NamespacedName{Name: node.Name}, &updated))
+	assert.Equal(t, "vm-100", updated.Annotations[OriginalNameAnnotation])
+	assert.Equal(t, "proxmox://east/100", updated.Annotations[VMAnnotation])
+	assert.Contains(t, updated.Finalizers, NodeFinalizer)
+	assert.Equal(t, "web tier\n"+originalNameMarker+"vm-100", description)
+}
+
+func TestNodeReconciler_Reconcile_KeepDoesNotTrack(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-01"},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-1"}},
+	}
+	mock := &MockProxmoxClient{
+		GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+			return &proxmox.VM{ID: 100, Name: "worker-01", Node: "pve-1", Cluster: "east", UUID: uuid}, nil
+		},
+		UpdateVMDescriptionFn: func(ctx context.Context, vm *proxmox.VM, description string) error {
+			t.Errorf("unexpected description update of VM %d", vm.ID)
+			return nil
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	r := NewNodeReconciler(c, scheme, mock)
+
+	_, err := r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+	require.NoError(t, err)
+
+	var updated corev1.Node
+	require.NoError(t, c.Get(t.Context(), types.NamespacedName{Name: node.Name}, &updated))
+	assert.Empty(t, updated.Annotations)
+	assert.Empty(t, updated.Finalizers)
+}
+
+func TestNodeReconciler_Reconcile_RefusedRenameDoesNotTrack(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		vm       *proxmox.VM
+		template string
+		existing []proxmox.VM
+	}{
+		{
+			name:     "name used by another VM",
+			vm:       &proxmox.VM{ID: 100, Name: "vm-100", Node: "pve-1", Cluster: "east"},
+			template: DefaultNameTemplate,
+			existing: []proxmox.VM{{ID: 200, Name: "worker-01.example.com", Node: "pve-2", Cluster: "east"}},
+		},
+		{
+			name:     "container named unlike its node",
+			vm:       &proxmox.VM{ID: 101, Name: "ct-101", Node: "pve-1", Cluster: "east", GuestType: proxmox.GuestTypeLXC},
+			template: "{{.ShortName}}",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker-01.example.com"},
+				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-1"}},
+			}
+			mock := &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					return tc.vm, nil
+				},
+				GetVMsByNameFn: func(ctx context.Context, cluster, name string) ([]proxmox.VM, error) {
+					return tc.existing, nil
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, name string) error {
+					t.Errorf("unexpected rename of VM %d to %q", vm.ID, name)
+					return nil
+				},
+				UpdateVMDescriptionFn: func(ctx context.Context, vm *proxmox.VM, description string) error {
+					t.Errorf("unexpected description update of VM %d", vm.ID)
+					return nil
+				},
+			}
+
+			policy, err := NewNamePolicy(tc.template, "")
+			require.NoError(t, err)
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+			r := NewNodeReconciler(c, scheme, mock)
+			r.NamePolicy = policy
+			r.NodeDeletionPolicy = NodeDeletionRestore
+
+			_, err = r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+			require.NoError(t, err)
+
+			var updated corev1.Node
+			require.NoError(t, c.Get(t.Context(), types.NamespacedName{Name: node.Name}, &updated))
+			assert.Empty(t, updated.Annotations)
+			assert.Empty(t, updated.Finalizers)
+		})
+	}
+}
+
+func TestNodeReconciler_Reconcile_DeletedNode(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	marked := withOriginalName("web tier", "vm-100")
+	tests := []struct {
+		name            string
+		policy          NodeDeletionPolicy
+		vm              *proxmox.VM
+		wantName        string
+		wantDescription string
+	}{
+		{
+			name:            "restore",
+			policy:          NodeDeletionRestore,
+			vm:              &proxmox.VM{ID: 100, Name: "worker-01", Cluster: "east", Description: marked},
+			wantName:        "vm-100",
+			wantDescription: "web tier",
+		},
+		{
+			name:     "orphan",
+			policy:   NodeDeletionOrphan,
+			vm:       &proxmox.VM{ID: 100, Name: "worker-01", Cluster: "east", Description: marked},
+			wantName: "orphaned-worker-01",
+		},
+		{
+			name:   "VM id reused by an unmarked VM",
+			policy: NodeDeletionRestore,
+			vm:     &proxmox.VM{ID: 100, Name: "other", Cluster: "east"},
+		},
+		{
+			name:   "VM gone",
+			policy: NodeDeletionOrphan,
+		},
+		{
+			name:   "keep",
+			policy: NodeDeletionKeep,
+			vm:     &proxmox.VM{ID: 100, Name: "worker-01", Cluster: "east", Description: marked},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:              "worker-01",
+				DeletionTimestamp: &metav1.Time{Time: metav1.Now().Time},
+				Finalizers:        []string{NodeFinalizer},
+				Annotations: map[string]string{
+					OriginalNameAnnotation: "vm-100",
+					VMAnnotation:           "proxmox://east/100",
+				},
+			}}
+			var newName, description string
+			mock := &MockProxmoxClient{
+				GetVMByIDFn: func(ctx context.Context, cluster string, vmid int) (*proxmox.VM, error) {
+					assert.Equal(t, "east", cluster)
+					assert.Equal(t, 100, vmid)
+					return tc.vm, nil
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, name string) error {
+					newName = name
+					return nil
+				},
+				UpdateVMDescriptionFn: func(ctx context.Context, vm *proxmox.VM, newDescription string) error {
+					description = newDescription
+					return nil
+				},
+			}
+
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+			r := NewNodeReconciler(c, scheme, mock)
+			r.NodeDeletionPolicy = tc.policy
+
+			_, err := r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantName, newName)
+			assert.Equal(t, tc.wantDescription, description)
+
+			var deleted corev1.Node
+			err = c.Get(t.Context(), types.NamespacedName{Name: node.Name}, &deleted)
+			assert.True(t, apierrors.IsNotFound(err), "the finalizer is removed and the node deleted")
+		})
+	}
+}
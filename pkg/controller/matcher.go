@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/proxmox"
+)
+
+// MACAddressesKey is the annotation, or label, in which node bootstrap
+// publishes the node's MAC addresses for MACMatcher. The annotation holds a
+// comma or space separated list. Label values cannot contain colons or
+// commas, so the label separates addresses with "_" and writes them with
+// dashes, e.g. "bc-24-11-2a-3b-4c_bc-24-11-2a-3b-4d".
+const MACAddressesKey = "proxmox-name-sync-controller/mac-addresses"
+
+// Values of proxmox.VM.MatchedBy set by the reconciler.
+const (
+	MatchedByProviderID      = "provider-id"
+	MatchedByUUID            = "uuid"
+	MatchedByMixedEndianUUID = "uuid-mixed-endian"
+	MatchedByMAC             = "mac"
+	MatchedByHostname        = "hostname"
+	MatchedByGuestAgent      = "guest-agent"
+)
+
+// VMMatcher is one strategy for finding the VM backing a node. Match returns
+// nil without an error when the strategy does not apply to the node or finds
+// nothing; like the Proxmox client, it may return a VM together with a
+// *proxmox.PartialResultError.
+type VMMatcher interface {
+	Name() string
+	Match(ctx context.Context, node *corev1.Node) (*proxmox.VM, error)
+}
+
+// MatchStrategy names a VMMatcher for --match-strategies.
+type MatchStrategy string
+
+const (
+	MatchStrategyProviderID MatchStrategy = "provider-id"
+	MatchStrategyUUID       MatchStrategy = "uuid"
+	MatchStrategyMAC        MatchStrategy = "mac"
+	MatchStrategyHostname   MatchStrategy = "hostname"
+	MatchStrategyGuestAgent MatchStrategy = "guest-agent"
+)
+
+var matchStrategies = []MatchStrategy{
+	MatchStrategyProviderID, MatchStrategyUUID, MatchStrategyMAC, MatchStrategyHostname, MatchStrategyGuestAgent,
+}
+
+// ParseMatchStrategies validates a comma separated --match-strategies value.
+func ParseMatchStrategies(value string) ([]MatchStrategy, error) {
+	var strategies []MatchStrategy
+	for name := range strings.SplitSeq(value, ",") {
+		strategy := MatchStrategy(strings.TrimSpace(name))
+		if !slices.Contains(matchStrategies, strategy) {
+			return nil, fmt.Errorf("unknown match strategy %q, must be one of %v", strategy, matchStrategies)
+		}
+		if slices.Contains(strategies, strategy) {
+			return nil, fmt.Errorf("match strategy %q is listed twice", strategy)
+		}
+		strategies = append(strategies, strategy)
+	}
+
+	return strategies, nil
+}
+
+// NewMatchers builds the matchers for strategies in the given order.
+func NewMatchers(proxmoxClient ProxmoxClientInterface, strategies []MatchStrategy, uuidMatchMode UUIDMatchMode) []VMMatcher {
+	matchers := make([]VMMatcher, 0, len(strategies))
+	for _, strategy := range strategies {
+		switch strategy {
+		case MatchStrategyProviderID:
+			matchers = append(matchers, &ProviderIDMatcher{Client: proxmoxClient})
+		case MatchStrategyUUID:
+			matchers = append(matchers, &UUIDMatcher{Client: proxmoxClient, Mode: uuidMatchMode})
+		case MatchStrategyMAC:
+			matchers = append(matchers, &MACMatcher{Client: proxmoxClient})
+		case MatchStrategyHostname:
+			matchers = append(matchers, &HostnameMatcher{Client: proxmoxClient})
+		case MatchStrategyGuestAgent:
+			matchers = append(matchers, &GuestAgentMatcher{Client: proxmoxClient})
+		}
+	}
+
+	return matchers
+}
+
+// matchVM tries the matchers in order and returns the first VM found. A
+// partial result without a match does not stop the chain, but is returned
+// when no later matcher finds the VM either.
+func matchVM(ctx context.Context, matchers []VMMatcher, node *corev1.Node) (*proxmox.VM, error) {
+	var partialErr error
+	for _, matcher := range matchers {
+		vm, err := matcher.Match(ctx, node)
+		if vm != nil {
+			if vm.MatchedBy == "" {
+				vm.MatchedBy = matcher.Name()
+			}
+			return vm, err
+		}
+
+		var partial *proxmox.PartialResultError
+		if errors.As(err, &partial) {
+			partialErr = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, partialErr
+}
+
+// ProviderIDMatcher resolves the VM directly from the node's spec.providerID
+// when it is a Proxmox providerID. Other and malformed providerIDs, and
+// providerIDs of clusters that are not configured, are skipped.
+type ProviderIDMatcher struct {
+	Client ProxmoxClientInterface
+}
+
+func (m *ProviderIDMatcher) Name() string { return string(MatchStrategyProviderID) }
+
+func (m *ProviderIDMatcher) Match(ctx context.Context, node *corev1.Node) (*proxmox.VM, error) {
+	if !strings.HasPrefix(node.Spec.ProviderID, proxmox.ProviderIDPrefix) {
+		return nil, nil
+	}
+
+	logger := log.FromContext(ctx).WithValues("node", node.Name, "providerID", node.Spec.ProviderID)
+	providerID, err := proxmox.ParseProviderID(node.Spec.ProviderID)
+	if err != nil {
+		logger.Info("Ignoring malformed providerID", "error", err.Error())
+		return nil, nil
+	}
+
+	var vm *proxmox.VM
+	if providerID.UUID != "" {
+		vm, err = m.Client.GetVMByUUID(ctx, providerID.UUID)
+	} else {
+		vm, err = m.Client.GetVMByID(ctx, providerID.Cluster, providerID.VMID)
+	}
+	if proxmox.KindOf(err) == proxmox.ErrorNotFound {
+		logger.Info("VM or cluster of providerID not found", "error", err.Error())
+		return nil, nil
+	}
+	if vm != nil {
+		vm.MatchedBy = MatchedByProviderID
+	}
+
+	return vm, err
+}
+
+// UUIDMatcher looks up the VM by the node's SystemUUID and, in mixed-endian
+// mode, by the byte-swapped SystemUUID when the first lookup finds nothing.
+type UUIDMatcher struct {
+	Client ProxmoxClientInterface
+	Mode   UUIDMatchMode
+}
+
+func (m *UUIDMatcher) Name() string { return string(MatchStrategyUUID) }
+
+func (m *UUIDMatcher) Match(ctx context.Context, node *corev1.Node) (*proxmox.VM, error) {
+	uuid := node.Status.NodeInfo.SystemUUID
+	if uuid == "" {
+		return nil, nil
+	}
+
+	vm, err := m.Client.GetVMByUUID(ctx, uuid)
+	if vm != nil {
+		vm.MatchedBy = MatchedByUUID
+		return vm, err
+	}
+
+	var partial *proxmox.PartialResultError
+	if m.Mode != UUIDMatchMixedEndian || (err != nil && !errors.As(err, &partial)) {
+		return nil, err
+	}
+
+	swapped, swapErr := proxmox.MixedEndianUUID(uuid)
+	if swapErr != nil {
+		log.FromContext(ctx).Info("SystemUUID is not a UUID, skipping mixed-endian lookup", "node", node.Name, "systemUUID", uuid)
+		return nil, err
+	}
+
+	swappedVM, swappedErr := m.Client.GetVMByUUID(ctx, swapped)
+	if swappedVM != nil {
+		swappedVM.MatchedBy = MatchedByMixedEndianUUID
+		return swappedVM, swappedErr
+	}
+	if err == nil {
+		err = swappedErr
+	}
+
+	return nil, err
+}
+
+// MACMatcher looks up the VM by the MAC addresses published on the node
+// under MACAddressesKey. Nodes without them are skipped.
+type MACMatcher struct {
+	Client ProxmoxClientInterface
+}
+
+func (m *MACMatcher) Name() string { return string(MatchStrategyMAC) }
+
+func (m *MACMatcher) Match(ctx context.Context, node *corev1.Node) (*proxmox.VM, error) {
+	macs := nodeMACAddresses(node)
+	if len(macs) == 0 {
+		return nil, nil
+	}
+
+	vm, err := m.Client.GetVMByMAC(ctx, macs)
+	if vm != nil {
+		vm.MatchedBy = MatchedByMAC
+	}
+
+	return vm, err
+}
+
+// nodeMACAddresses reads the MAC addresses of node, preferring the annotation
+// over the label.
+func nodeMACAddresses(node *corev1.Node) []string {
+	if value := node.Annotations[MACAddressesKey]; value != "" {
+		return strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	if value := node.Labels[MACAddressesKey]; value != "" {
+		return strings.Split(value, "_")
+	}
+
+	return nil
+}
+
+// GuestAgentMatcher looks up the VM whose QEMU guest agent reports the node's
+// name, a Hostname address or an InternalIP or ExternalIP address. Every
+// agent is queried, so it is best placed last in the chain.
+type GuestAgentMatcher struct {
+	Client ProxmoxClientInterface
+}
+
+func (m *GuestAgentMatcher) Name() string { return string(MatchStrategyGuestAgent) }
+
+func (m *GuestAgentMatcher) Match(ctx context.Context, node *corev1.Node) (*proxmox.VM, error) {
+	vm, err := m.Client.GetVMByGuestAgent(ctx, nodeHostnames(node), nodeIPs(node))
+	if vm != nil {
+		vm.MatchedBy = MatchedByGuestAgent
+	}
+
+	return vm, err
+}
+
+// HostnameMatcher looks up the LXC container whose configured hostname is the
+// node's name or a Hostname address. Containers have no SMBIOS UUID.
+type HostnameMatcher struct {
+	Client ProxmoxClientInterface
+}
+
+func (m *HostnameMatcher) Name() string { return string(MatchStrategyHostname) }
+
+func (m *HostnameMatcher) Match(ctx context.Context, node *corev1.Node) (*proxmox.VM, error) {
+	vm, err := m.Client.GetVMByHostname(ctx, nodeHostnames(node))
+	if vm != nil {
+		vm.MatchedBy = MatchedByHostname
+	}
+
+	return vm, err
+}
+
+// nodeHostnames returns the node's name followed by its Hostname addresses.
+func nodeHostnames(node *corev1.Node) []string {
+	hostnames := []string{node.Name}
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeHostName && address.Address != node.Name {
+			hostnames = append(hostnames, address.Address)
+		}
+	}
+
+	return hostnames
+}
+
+// nodeIPs returns the node's InternalIP and ExternalIP addresses.
+func nodeIPs(node *corev1.Node) []string {
+	var ips []string
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP || address.Type == corev1.NodeExternalIP {
+			ips = append(ips, address.Address)
+		}
+	}
+
+	return ips
+}
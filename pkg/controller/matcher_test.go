@@ -0,0 +1,288 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseMatchStrategies(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []MatchStrategy
+		wantErr bool
+	}{
+		{value: "uuid", want: []MatchStrategy{MatchStrategyUUID}},
+		{value: "mac, uuid", want: []MatchStrategy{MatchStrategyMAC, MatchStrategyUUID}},
+		{value: "provider-id,uuid,mac", want: []MatchStrategy{MatchStrategyProviderID, MatchStrategyUUID, MatchStrategyMAC}},
+		{value: "uuid,serial", wantErr: true},
+		{value: "uuid,uuid", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := ParseMatchStrategies(tc.value)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestNodeMACAddresses(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		want        []string
+	}{
+		{name: "none"},
+		{
+			name:        "annotation",
+			annotations: map[string]string{MACAddressesKey: "BC:24:11:2A:3B:4C, bc:24:11:2a:3b:4d"},
+			want:        []string{"BC:24:11:2A:3B:4C", "bc:24:11:2a:3b:4d"},
+		},
+		{
+			name:   "label",
+			labels: map[string]string{MACAddressesKey: "bc-24-11-2a-3b-4c_bc-24-11-2a-3b-4d"},
+			want:   []string{"bc-24-11-2a-3b-4c", "bc-24-11-2a-3b-4d"},
+		},
+		{
+			name:        "annotation wins over label",
+			annotations: map[string]string{MACAddressesKey: "bc:24:11:2a:3b:4c"},
+			labels:      map[string]string{MACAddressesKey: "bc-24-11-2a-3b-4d"},
+			want:        []string{"bc:24:11:2a:3b:4c"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations, Labels: tc.labels}}
+			assert.Equal(t, tc.want, nodeMACAddresses(node))
+		})
+	}
+}
+
+func TestNodeReconciler_Reconcile_MACFallback(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	withMACs := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "worker-01",
+			Annotations: map[string]string{MACAddressesKey: "bc:24:11:2a:3b:4c"},
+		},
+	}
+	withoutMACs := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-02"},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-2"}},
+	}
+
+	tests := []struct {
+		name          string
+		node          corev1.Node
+		strategies    []MatchStrategy
+		uuidErr       error
+		wantMatchedBy string
+		wantErr       error
+		wantMACLookup bool
+	}{
+		{
+			name:          "empty SystemUUID falls back to MAC",
+			node:          withMACs,
+			strategies:    []MatchStrategy{MatchStrategyUUID, MatchStrategyMAC},
+			wantMatchedBy: MatchedByMAC,
+			wantMACLookup: true,
+		},
+		{
+			name:          "partial UUID search falls back to MAC",
+			node:          *withUUID(withMACs.DeepCopy(), "uuid-1"),
+			strategies:    []MatchStrategy{MatchStrategyUUID, MatchStrategyMAC},
+			uuidErr:       errPartial,
+			wantMatchedBy: MatchedByMAC,
+			wantMACLookup: true,
+		},
+		{
+			name:       "failed UUID search stops the chain",
+			node:       *withUUID(withMACs.DeepCopy(), "uuid-1"),
+			strategies: []MatchStrategy{MatchStrategyUUID, MatchStrategyMAC},
+			uuidErr:    errUnreachable,
+			wantErr:    errUnreachable,
+		},
+		{
+			name:       "node without MACs is not looked up",
+			node:       withoutMACs,
+			strategies: []MatchStrategy{MatchStrategyMAC},
+		},
+		{
+			name:       "MAC strategy disabled",
+			node:       withMACs,
+			strategies: []MatchStrategy{MatchStrategyUUID},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var macLookup bool
+			var renamed *proxmox.VM
+			mock := &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					return nil, tc.uuidErr
+				},
+				GetVMByMACFn: func(ctx context.Context, macs []string) (*proxmox.VM, error) {
+					macLookup = true
+					return &proxmox.VM{ID: 100, Name: "old-name", Node: "pve-1", MACs: macs}, nil
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+					renamed = vm
+					return nil
+				},
+			}
+
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tc.node.DeepCopy()).Build()
+			r := NewNodeReconciler(c, scheme, mock)
+			r.Matchers = NewMatchers(mock, tc.strategies, UUIDMatchExact)
+
+			_, err := r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: tc.node.Name}})
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantMACLookup, macLookup)
+
+			if tc.wantMatchedBy == "" {
+				assert.Nil(t, renamed)
+				return
+			}
+			if assert.NotNil(t, renamed) {
+				assert.Equal(t, tc.wantMatchedBy, renamed.MatchedBy)
+			}
+		})
+	}
+}
+
+func TestProviderIDMatcher(t *testing.T) {
+	vmByID := &proxmox.VM{ID: 100, Name: "vm-100", Node: "pve-1", Cluster: "east"}
+	vmByUUID := &proxmox.VM{ID: 200, Name: "vm-200", Node: "pve-2", Cluster: "west"}
+	mock := &MockProxmoxClient{
+		GetVMByIDFn: func(ctx context.Context, cluster string, vmid int) (*proxmox.VM, error) {
+			switch {
+			case cluster == "east" && vmid == 100:
+				vm := *vmByID
+				return &vm, nil
+			case cluster == "east":
+				return nil, nil
+			case cluster == "west" && vmid == 1:
+				return nil, errUnreachable
+			default:
+				return nil, &proxmox.Error{Kind: proxmox.ErrorNotFound, Err: errors.New("unknown Proxmox cluster")}
+			}
+		},
+		GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+			if uuid == "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b" {
+				vm := *vmByUUID
+				return &vm, nil
+			}
+			return nil, nil
+		},
+	}
+
+	tests := []struct {
+		providerID string
+		wantID     int
+		wantErr    error
+	}{
+		{providerID: "proxmox://east/100", wantID: 100},
+		{providerID: "proxmox://6F1D2C3B-4A59-4E8F-9B7A-0C1D2E3F4A5B", wantID: 200},
+		{providerID: "proxmox://east/101"},
+		{providerID: "proxmox://north/100"},
+		{providerID: "proxmox://west/1", wantErr: errUnreachable},
+		{providerID: "proxmox://east/abc"},
+		{providerID: "aws:///eu-west-1a/i-0123456789"},
+		{providerID: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.providerID, func(t *testing.T) {
+			node := &corev1.Node{Spec: corev1.NodeSpec{ProviderID: tc.providerID}}
+			vm, err := (&ProviderIDMatcher{Client: mock}).Match(t.Context(), node)
+			assert.Equal(t, tc.wantErr, err)
+			if tc.wantID == 0 {
+				assert.Nil(t, vm)
+				return
+			}
+			if assert.NotNil(t, vm) {
+				assert.Equal(t, tc.wantID, vm.ID)
+				assert.Equal(t, MatchedByProviderID, vm.MatchedBy)
+			}
+		})
+	}
+}
+
+func TestGuestAgentMatcher(t *testing.T) {
+	var gotHostnames, gotIPs []string
+	mock := &MockProxmoxClient{
+		GetVMByAgentFn: func(ctx context.Context, hostnames []string, ips []string) (*proxmox.VM, error) {
+			gotHostnames, gotIPs = hostnames, ips
+			return &proxmox.VM{ID: 100, Name: "vm-100", Node: "pve-1"}, nil
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-01"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "worker-01.example.com"},
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+			{Type: corev1.NodeExternalIP, Address: "2001:db8::5"},
+			{Type: corev1.NodeInternalDNS, Address: "worker-01.internal"},
+		}},
+	}
+
+	vm, err := (&GuestAgentMatcher{Client: mock}).Match(t.Context(), node)
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, MatchedByGuestAgent, vm.MatchedBy)
+	assert.Equal(t, []string{"worker-01", "worker-01.example.com"}, gotHostnames)
+	assert.Equal(t, []string{"10.0.0.5", "2001:db8::5"}, gotIPs)
+}
+
+func TestHostnameMatcher(t *testing.T) {
+	var gotHostnames []string
+	mock := &MockProxmoxClient{
+		GetVMByHostFn: func(ctx context.Context, hostnames []string) (*proxmox.VM, error) {
+			gotHostnames = hostnames
+			return &proxmox.VM{ID: 101, Name: "edge-01", Node: "pve-1", GuestType: proxmox.GuestTypeLXC}, nil
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge-01"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "edge-01"},
+			{Type: corev1.NodeHostName, Address: "edge-01.example.com"},
+		}},
+	}
+
+	vm, err := (&HostnameMatcher{Client: mock}).Match(t.Context(), node)
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, MatchedByHostname, vm.MatchedBy)
+	assert.Equal(t, []string{"edge-01", "edge-01.example.com"}, gotHostnames, "node name is not repeated")
+}
+
+func withUUID(node *corev1.Node, uuid string) *corev1.Node {
+	node.Status.NodeInfo.SystemUUID = uuid
+	return node
+}
@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var pendingRenamesGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "proxmox_name_sync_pending_renames",
+	Help: "Number of nodes whose VM does not have the desired name yet, e.g. because of a name collision, " +
+		"a locked VM or dry-run mode.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(pendingRenamesGauge)
+}
+
+// pendingRenames tracks the nodes whose VM awaits a rename for
+// pendingRenamesGauge.
+type pendingRenames struct {
+	mu    sync.Mutex
+	nodes map[string]struct{}
+}
+
+func (p *pendingRenames) set(node string, pending bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.nodes == nil {
+		p.nodes = make(map[string]struct{})
+	}
+	if pending {
+		p.nodes[node] = struct{}{}
+	} else {
+		delete(p.nodes, node)
+	}
+	pendingRenamesGauge.Set(float64(len(p.nodes)))
+}
+
+// count returns the number of pending renames.
+func (p *pendingRenames) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.nodes)
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultNameTemplate names VMs exactly like their nodes.
+const DefaultNameTemplate = "{{.NodeName}}"
+
+// NameTemplateData is what a --vm-name-template can refer to.
+type NameTemplateData struct {
+	// NodeName is the name of the Kubernetes node.
+	NodeName string
+	// ShortName is NodeName up to its first dot, i.e. without domain.
+	ShortName string
+	// ClusterName is the value of --cluster-name.
+	ClusterName string
+	// Labels are the node's labels; missing labels are empty, e.g.
+	// {{index .Labels "topology.kubernetes.io/zone"}}.
+	Labels map[string]string
+}
+
+// nameTemplateFuncs are the functions available to name templates besides
+// the text/template builtins.
+var nameTemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+}
+
+// NamePolicy computes the desired VM name of a node from a Go template over
+// NameTemplateData.
+type NamePolicy struct {
+	template    *template.Template
+	clusterName string
+}
+
+// NewNamePolicy parses text and renders it for a sample node, so that
+// syntax errors and unknown fields or functions are reported at startup.
+func NewNamePolicy(text, clusterName string) (*NamePolicy, error) {
+	tmpl, err := template.New("vm-name").Option("missingkey=zero").Funcs(nameTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse VM name template: %w", err)
+	}
+
+	policy := &NamePolicy{template: tmpl, clusterName: clusterName}
+	sample := &corev1.Node{}
+	sample.Name = "node-1.example.com"
+	if _, err := policy.render(sample); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Name renders the VM name of node. Surrounding whitespace is trimmed and an
+// empty name is an error.
+func (p *NamePolicy) Name(node *corev1.Node) (string, error) {
+	name, err := p.render(node)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", fmt.Errorf("VM name template rendered an empty name for node %s", node.Name)
+	}
+
+	return name, nil
+}
+
+func (p *NamePolicy) render(node *corev1.Node) (string, error) {
+	shortName, _, _ := strings.Cut(node.Name, ".")
+	data := NameTemplateData{
+		NodeName:    node.Name,
+		ShortName:   shortName,
+		ClusterName: p.clusterName,
+		Labels:      node.Labels,
+	}
+
+	var b strings.Builder
+	if err := p.template.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render VM name template: %w", err)
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+// MaxVMNameLength is the longest VM name SanitizeVMName returns. Proxmox itself
+// only requires a DNS name, but cloud-init sets the guest's hostname from the
+// VM name and Linux limits hostnames to 64 bytes.
+const MaxVMNameLength = 63
+
+// nameHashLength is the number of hex digits of the hash suffix appended to
+// truncated names.
+const nameHashLength = 8
+
+// SanitizeVMName turns name into a DNS name as Proxmox requires for VM names
+// and container hostnames: characters other than letters, digits, dots and
+// hyphens become hyphens, and labels lose leading and trailing hyphens.
+// Names longer than MaxVMNameLength are truncated and suffixed with a hash of
+// name, so distinct long names stay distinct and the result is stable.
+func SanitizeVMName(name string) (string, error) {
+	replaced := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '-'
+		}
+	}, name)
+
+	sanitized := joinLabels(strings.Split(replaced, "."))
+	if sanitized == "" {
+		return "", fmt.Errorf("VM name %q has no valid characters", name)
+	}
+	if len(sanitized) <= MaxVMNameLength {
+		return sanitized, nil
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:nameHashLength]
+	truncated := sanitized[:MaxVMNameLength-nameHashLength-1]
+
+	return joinLabels(strings.Split(truncated, ".")) + "-" + suffix, nil
+}
+
+// joinLabels trims hyphens off labels and joins the non-empty ones with dots.
+func joinLabels(labels []string) string {
+	kept := labels[:0]
+	for _, label := range labels {
+		if label = strings.Trim(label, "-"); label != "" {
+			kept = append(kept, label)
+		}
+	}
+
+	return strings.Join(kept, ".")
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNamePolicy(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "worker-01.example.com",
+		Labels: map[string]string{"pool": "gpu", "topology.kubernetes.io/zone": "rack-2"},
+	}}
+
+	tests := []struct {
+		template   string
+		want       string
+		wantErr    bool
+		invalidErr bool
+	}{
+		{template: DefaultNameTemplate, want: "worker-01.example.com"},
+		{template: "{{.ShortName}}", want: "worker-01"},
+		{template: "{{.ClusterName}}-{{.ShortName}}", want: "prod-worker-01"},
+		{template: "{{.Labels.pool}}-{{.ShortName}}", want: "gpu-worker-01"},
+		{template: `{{index .Labels "topology.kubernetes.io/zone"}}-{{.ShortName}}`, want: "rack-2-worker-01"},
+		{template: `{{trimSuffix ".example.com" .NodeName | upper}}`, want: "WORKER-01"},
+		{template: `{{replace "." "-" .NodeName}}`, want: "worker-01-example-com"},
+		{template: " {{.Labels.missing}} ", wantErr: true},
+		{template: "{{.Hostname}}", invalidErr: true},
+		{template: "{{.NodeName", invalidErr: true},
+		{template: "{{title .NodeName}}", invalidErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.template, func(t *testing.T) {
+			policy, err := NewNamePolicy(tc.template, "prod")
+			if tc.invalidErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			got, err := policy.Name(node)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestNodeReconciler_Reconcile_NamePolicy(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-01.example.com"},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-1"}},
+	}
+	var newName string
+	mock := &MockProxmoxClient{
+		GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+			return &proxmox.VM{ID: 100, Name: "worker-01.example.com", Node: "pve-1", UUID: uuid}, nil
+		},
+		UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, name string) error {
+			newName = name
+			return nil
+		},
+	}
+
+	policy, err := NewNamePolicy("{{.ClusterName}}-{{.ShortName}}", "prod")
+	require.NoError(t, err)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	r := NewNodeReconciler(c, scheme, mock)
+	r.NamePolicy = policy
+
+	_, err = r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, "prod-worker-01", newName)
+}
+
+func TestNodeReconciler_Reconcile_ContainerName(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		template  string
+		wantName  string
+		wantEvent string
+	}{
+		{template: DefaultNameTemplate, wantName: "worker-01.example.com"},
+		{template: "{{.ShortName}}", wantEvent: "Warning ContainerNameRefused"},
+	} {
+		t.Run(tc.template, func(t *testing.T) {
+			node := &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "worker-01.example.com"},
+				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-1"}},
+			}
+			var newName string
+			mock := &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					return &proxmox.VM{ID: 101, Name: "ct-101", Node: "pve-1", GuestType: proxmox.GuestTypeLXC}, nil
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, name string) error {
+					newName = name
+					return nil
+				},
+			}
+
+			policy, err := NewNamePolicy(tc.template, "")
+			require.NoError(t, err)
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+			recorder := record.NewFakeRecorder(1)
+			r := NewNodeReconciler(c, scheme, mock)
+			r.NamePolicy = policy
+			r.Recorder = recorder
+
+			_, err = r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantName, newName)
+			if tc.wantEvent == "" {
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			require.Len(t, recorder.Events, 1)
+			assert.Contains(t, <-recorder.Events, tc.wantEvent)
+		})
+	}
+}
+
+func TestSanitizeVMName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "worker-01", want: "worker-01"},
+		{name: "worker-01.example.com", want: "worker-01.example.com"},
+		{name: "Worker_01", want: "Worker-01"},
+		{name: "-edge..01-", want: "edge.01"},
+		{name: "gpu pool/worker 01", want: "gpu-pool-worker-01"},
+		{name: "node-ü", want: "node"},
+		{name: "__", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := SanitizeVMName(tc.name)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestSanitizeVMName_Truncates(t *testing.T) {
+	long := strings.Repeat("a", 60) + ".example.com"
+	otherLong := strings.Repeat("a", 60) + ".example.org"
+
+	got, err := SanitizeVMName(long)
+	require.NoError(t, err)
+	assert.Len(t, got, MaxVMNameLength)
+	assert.True(t, strings.HasPrefix(got, strings.Repeat("a", 54)+"-"), got)
+
+	again, err := SanitizeVMName(long)
+	require.NoError(t, err)
+	assert.Equal(t, got, again, "the hash suffix is stable")
+
+	other, err := SanitizeVMName(otherLong)
+	require.NoError(t, err)
+	assert.NotEqual(t, got, other, "names differing after the cut stay distinct")
+
+	dotted, err := SanitizeVMName(strings.Repeat("a", 53) + ".b" + strings.Repeat("c", 20))
+	require.NoError(t, err)
+	assert.NotContains(t, dotted, ".-", "no empty label before the hash suffix")
+}
+
+func TestNodeReconciler_Reconcile_SanitizedName(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-01", Labels: map[string]string{"pool": "gpu_a"}},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-1"}},
+	}
+	var newName string
+	mock := &MockProxmoxClient{
+		GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+			return &proxmox.VM{ID: 100, Name: "vm-100", Node: "pve-1", UUID: uuid}, nil
+		},
+		UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, name string) error {
+			newName = name
+			return nil
+		},
+	}
+
+	policy, err := NewNamePolicy("{{.Labels.pool}}_{{.NodeName}}", "")
+	require.NoError(t, err)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node).Build()
+	recorder := record.NewFakeRecorder(1)
+	r := NewNodeReconciler(c, scheme, mock)
+	r.NamePolicy = policy
+	r.Recorder = recorder
+
+	_, err = r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, "gpu-a-worker-01", newName)
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Normal VMNameSanitized")
+}
@@ -2,10 +2,13 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -14,23 +17,101 @@ import (
 )
 
 const requeueDuration = time.Second * 30
-const proxmoxInternalErr = ProxmoxErr("proxmox internal error")
 
-type ProxmoxErr string
+// authErrorRequeueDuration is used while Proxmox rejects the configured
+// credentials or privileges; retrying sooner only adds failed requests.
+const authErrorRequeueDuration = time.Minute * 10
 
-func (pe ProxmoxErr) Error() string {
-	return string(pe)
-}
+// lockedRequeueDuration is used while the VM is locked by a backup, migration
+// or similar task.
+const lockedRequeueDuration = time.Minute
+
+// duplicateUUIDRequeueDuration is used while several VMs share the node's
+// SystemUUID or otherwise match it equally well, which only an operator can
+// resolve.
+const duplicateUUIDRequeueDuration = time.Minute * 5
+
+// Reasons of the events recorded on nodes.
+const (
+	// EventReasonDuplicateUUID is recorded when the node's SystemUUID is
+	// shared by several VMs.
+	EventReasonDuplicateUUID = "DuplicateVMUUID"
+	// EventReasonAmbiguousMatch is recorded when several VMs match the node
+	// equally well.
+	EventReasonAmbiguousMatch = "AmbiguousVMMatch"
+	// EventReasonNameSanitized is recorded when the node's VM name had to be
+	// changed to be valid in Proxmox.
+	EventReasonNameSanitized = "VMNameSanitized"
+	// EventReasonContainerNameRefused is recorded when a container would be
+	// named other than its node. A container's name is its hostname.
+	EventReasonContainerNameRefused = "ContainerNameRefused"
+	// EventReasonDryRunRename is recorded for every rename skipped in dry-run
+	// mode.
+	EventReasonDryRunRename = "DryRunVMRename"
+	// EventReasonDryRunFinalize is recorded when the finalizer of a deleted
+	// node is left in place in dry-run mode.
+	EventReasonDryRunFinalize = "DryRunNodeFinalize"
+)
 
 type ProxmoxClientInterface interface {
 	GetVMByUUID(ctx context.Context, uuid string) (*proxmox.VM, error)
-	UpdateVMName(ctx context.Context, nodeName string, vmid int, newName string) error
+	GetVMByMAC(ctx context.Context, macs []string) (*proxmox.VM, error)
+	// GetVMByID reads a VM directly; cluster may be empty to search all clusters.
+	GetVMByID(ctx context.Context, cluster string, vmid int) (*proxmox.VM, error)
+	GetVMByGuestAgent(ctx context.Context, hostnames []string, ips []string) (*proxmox.VM, error)
+	GetVMByHostname(ctx context.Context, hostnames []string) (*proxmox.VM, error)
+	// GetVMsByName returns the VMs named name in the given cluster.
+	GetVMsByName(ctx context.Context, cluster string, name string) ([]proxmox.VM, error)
+	UpdateVMName(ctx context.Context, vm *proxmox.VM, newName string) error
+	UpdateVMDescription(ctx context.Context, vm *proxmox.VM, description string) error
+}
+
+// UUIDMatchMode selects which forms of the node's SystemUUID are looked up.
+type UUIDMatchMode string
+
+const (
+	// UUIDMatchExact only looks up the SystemUUID as reported by the node.
+	UUIDMatchExact UUIDMatchMode = "exact"
+	// UUIDMatchMixedEndian also looks up the SystemUUID with the byte order of
+	// its first three groups swapped, as reported by some firmware.
+	UUIDMatchMixedEndian UUIDMatchMode = "mixed-endian"
+)
+
+// ParseUUIDMatchMode validates a --uuid-match-mode value.
+func ParseUUIDMatchMode(mode string) (UUIDMatchMode, error) {
+	switch UUIDMatchMode(mode) {
+	case UUIDMatchExact, UUIDMatchMixedEndian:
+		return UUIDMatchMode(mode), nil
+	default:
+		return "", fmt.Errorf("unknown UUID match mode %q, must be %q or %q", mode, UUIDMatchExact, UUIDMatchMixedEndian)
+	}
 }
 
 type NodeReconciler struct {
 	client.Client
 	Scheme        *runtime.Scheme
 	ProxmoxClient ProxmoxClientInterface
+	// Matchers are tried in order until one finds the node's VM. It defaults
+	// to exact SystemUUID matching.
+	Matchers []VMMatcher
+	// CollisionPolicy applies when the VM name is used by another VM. It
+	// defaults to CollisionRefuse.
+	CollisionPolicy CollisionPolicy
+	// NodeDeletionPolicy decides what happens to the VM of a deleted node. It
+	// defaults to NodeDeletionKeep.
+	NodeDeletionPolicy NodeDeletionPolicy
+	// NamePolicy computes the name of a node's VM. Without one, VMs are named
+	// exactly like their nodes.
+	NamePolicy *NamePolicy
+	// DryRun makes the reconciler log and record events for the renames it
+	// would do without changing anything in Proxmox or on nodes.
+	DryRun bool
+	// Recorder, if set, records events on nodes whose VM cannot be matched
+	// unambiguously, whose VM name had to be sanitized or, in dry-run mode,
+	// whose VM would be renamed.
+	Recorder record.EventRecorder
+
+	pending pendingRenames
 }
 
 func NewNodeReconciler(k8sClient client.Client, scheme *runtime.Scheme, proxmoxClient ProxmoxClientInterface) *NodeReconciler {
@@ -38,6 +119,10 @@ func NewNodeReconciler(k8sClient client.Client, scheme *runtime.Scheme, proxmoxC
 		Client:        k8sClient,
 		Scheme:        scheme,
 		ProxmoxClient: proxmoxClient,
+		Matchers:      []VMMatcher{&UUIDMatcher{Client: proxmoxClient, Mode: UUIDMatchExact}},
+
+		CollisionPolicy:    CollisionRefuse,
+		NodeDeletionPolicy: NodeDeletionKeep,
 	}
 }
 
@@ -47,43 +132,106 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	var node corev1.Node
 	if err := r.Get(ctx, req.NamespacedName, &node); err != nil {
 		logger.Info("Node not found, probably deleted", "node", req.Name)
+		r.pending.set(req.Name, false)
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	if !node.DeletionTimestamp.IsZero() {
+		r.pending.set(node.Name, false)
+		return r.finalizeNode(ctx, &node)
+	}
+
 	if r.isControlPlaneNode(&node) {
 		logger.Info("Skipping control plane node", "node", node.Name)
+		r.pending.set(node.Name, false)
+		return ctrl.Result{RequeueAfter: requeueDuration}, nil
+	}
+
+	desiredName, unsanitizedName, err := r.desiredName(&node)
+	if err != nil {
+		logger.Error(err, "Failed to compute VM name", "node", node.Name)
 		return ctrl.Result{RequeueAfter: requeueDuration}, nil
 	}
 
 	logger.Info("Reconciling node", "node", node.Name)
-	vm, err := r.ProxmoxClient.GetVMByUUID(ctx, node.Status.NodeInfo.SystemUUID)
+	vm, err := matchVM(ctx, r.Matchers, &node)
+	var partial *proxmox.PartialResultError
+	if errors.As(err, &partial) && vm != nil {
+		logger.Info("Some Proxmox nodes could not be listed, continuing with VM found on a healthy node",
+			"node", node.Name,
+			"vmid", vm.ID,
+			"failedNodes", partial.FailedNodes())
+		err = nil
+	}
 	if err != nil {
-		logger.Error(err, "Failed to search for VM in Proxmox", "node", node.Name)
-		return ctrl.Result{}, proxmoxInternalErr
+		return r.handleProxmoxError(ctx, &node, "Failed to search for VM in Proxmox", err)
 	}
 
 	if vm == nil {
-		logger.Info("No corresponding VM found in Proxmox for node", "node", node.Name)
+		logger.Info("No corresponding VM found in Proxmox for node", "node", node.Name,
+			"systemUUID", node.Status.NodeInfo.SystemUUID, "macAddresses", nodeMACAddresses(&node))
+		r.pending.set(node.Name, false)
+		return ctrl.Result{RequeueAfter: requeueDuration}, nil
+	}
+
+	if vm.Name == desiredName {
+		logger.Info("VM name already matches node", "node", node.Name, "vmid", vm.ID, "vmName", vm.Name)
+		r.pending.set(node.Name, false)
 		return ctrl.Result{RequeueAfter: requeueDuration}, nil
 	}
+	r.pending.set(node.Name, true)
 
-	if vm.Name == node.Name {
-		logger.Info("VM name already matches node name", "node", node.Name, "vmid", vm.ID)
+	name, ok, err := r.resolveNameCollision(ctx, &node, vm, desiredName)
+	if err != nil {
+		return r.handleProxmoxError(ctx, &node, "Failed to resolve VM name collision in Proxmox", err, "vmid", vm.ID)
+	}
+	if !ok {
+		return ctrl.Result{RequeueAfter: requeueDuration}, nil
+	}
+	if vm.Name == name {
+		logger.Info("VM name already matches node", "node", node.Name, "vmid", vm.ID, "vmName", vm.Name)
+		r.pending.set(node.Name, false)
+		return ctrl.Result{RequeueAfter: requeueDuration}, nil
+	}
+	// Renaming a container sets its hostname, which the guest picks up on its
+	// next start and HostnameMatcher looks the container up by. Only the node
+	// name itself keeps both intact.
+	if vm.GuestType == proxmox.GuestTypeLXC && name != node.Name {
+		logger.Info("Not renaming container to a name other than its node's",
+			"node", node.Name, "vmid", vm.ID, "name", name)
+		r.event(&node, corev1.EventTypeWarning, EventReasonContainerNameRefused,
+			fmt.Sprintf("Not renaming container %d to %q, containers can only be named like their node", vm.ID, name))
 		return ctrl.Result{RequeueAfter: requeueDuration}, nil
 	}
 
-	logger.Info("Updating VM name to match node name",
+	// Only VMs about to be renamed are tracked, so that refused renames leave
+	// neither a finalizer on the node nor a marker on the VM.
+	if err := r.trackVM(ctx, &node, vm); err != nil {
+		return r.handleProxmoxError(ctx, &node, "Failed to record original VM name", err, "vmid", vm.ID)
+	}
+
+	if unsanitizedName != "" {
+		logger.Info("Sanitized VM name to satisfy Proxmox naming rules",
+			"node", node.Name, "name", unsanitizedName, "sanitizedName", desiredName)
+		r.event(&node, corev1.EventTypeNormal, EventReasonNameSanitized,
+			fmt.Sprintf("VM name %q was changed to %q to satisfy Proxmox naming rules", unsanitizedName, desiredName))
+	}
+
+	logger.Info("Updating VM name to match node",
 		"node", node.Name,
 		"vmid", vm.ID,
+		"matchedBy", vm.MatchedBy,
+		"status", vm.Status,
 		"currentVMName", vm.Name,
-		"newVMName", node.Name)
+		"newVMName", name)
 
-	if err := r.ProxmoxClient.UpdateVMName(ctx, vm.Node, vm.ID, node.Name); err != nil {
-		logger.Error(err, "Failed to update VM name in Proxmox",
-			"node", node.Name,
-			"vmid", vm.ID)
-		return ctrl.Result{}, proxmoxInternalErr
+	if err := r.updateVMName(ctx, &node, vm, name); err != nil {
+		return r.handleProxmoxError(ctx, &node, "Failed to update VM name in Proxmox", err, "vmid", vm.ID)
+	}
+	if r.DryRun {
+		return ctrl.Result{RequeueAfter: requeueDuration}, nil
 	}
+	r.pending.set(node.Name, false)
 
 	logger.Info("Successfully updated VM name in Proxmox",
 		"node", node.Name,
@@ -92,6 +240,81 @@ func (r *NodeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	return ctrl.Result{RequeueAfter: requeueDuration}, nil
 }
 
+// desiredName returns the name the node's VM should have and, when it had to
+// be sanitized, the name it was derived from.
+func (r *NodeReconciler) desiredName(node *corev1.Node) (string, string, error) {
+	name := node.Name
+	if r.NamePolicy != nil {
+		var err error
+		if name, err = r.NamePolicy.Name(node); err != nil {
+			return "", "", err
+		}
+	}
+
+	sanitized, err := SanitizeVMName(name)
+	if err != nil {
+		return "", "", err
+	}
+	if sanitized != name {
+		return sanitized, name, nil
+	}
+
+	return sanitized, "", nil
+}
+
+// updateVMName renames vm, or in dry-run mode only logs and records an event
+// for the rename on node.
+func (r *NodeReconciler) updateVMName(ctx context.Context, node *corev1.Node, vm *proxmox.VM, name string) error {
+	if !r.DryRun {
+		return r.ProxmoxClient.UpdateVMName(ctx, vm, name)
+	}
+
+	log.FromContext(ctx).Info("Dry run, not renaming VM", "node", node.Name, "vmid", vm.ID,
+		"currentVMName", vm.Name, "newVMName", name)
+	r.event(node, corev1.EventTypeNormal, EventReasonDryRunRename,
+		fmt.Sprintf("Dry run: would rename VM %d from %q to %q", vm.ID, vm.Name, name))
+	return nil
+}
+
+// event records an event on node when a Recorder is set.
+func (r *NodeReconciler) event(node *corev1.Node, eventType, reason, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(node, eventType, reason, message)
+	}
+}
+
+// handleProxmoxError logs err and picks the retry strategy for its kind.
+// Configuration problems, duplicate UUIDs and locked VMs are retried after a
+// fixed delay; everything else is returned so the work queue backs off
+// exponentially.
+func (r *NodeReconciler) handleProxmoxError(ctx context.Context, node *corev1.Node, msg string, err error, keysAndValues ...any) (ctrl.Result, error) {
+	kind := proxmox.KindOf(err)
+	logger := log.FromContext(ctx).WithValues("node", node.Name, "kind", kind).WithValues(keysAndValues...)
+
+	switch kind {
+	case proxmox.ErrorUnauthorized, proxmox.ErrorForbidden:
+		logger.Error(err, msg+"; check the Proxmox credentials and privileges")
+		return ctrl.Result{RequeueAfter: authErrorRequeueDuration}, nil
+	case proxmox.ErrorVMLocked:
+		logger.Info(msg+"; VM is locked, retrying later", "error", err.Error())
+		return ctrl.Result{RequeueAfter: lockedRequeueDuration}, nil
+	case proxmox.ErrorNotFound:
+		logger.Info(msg+"; VM no longer exists", "error", err.Error())
+		return ctrl.Result{RequeueAfter: requeueDuration}, nil
+	case proxmox.ErrorDuplicateUUID:
+		logger.Error(err, msg+"; regenerate the SMBIOS UUID of the cloned VMs")
+		r.event(node, corev1.EventTypeWarning, EventReasonDuplicateUUID, err.Error())
+		return ctrl.Result{RequeueAfter: duplicateUUIDRequeueDuration}, nil
+	case proxmox.ErrorAmbiguousMatch:
+		logger.Error(err, msg+"; make the node's hostname or addresses unique to one VM")
+		r.event(node, corev1.EventTypeWarning, EventReasonAmbiguousMatch, err.Error())
+		return ctrl.Result{RequeueAfter: duplicateUUIDRequeueDuration}, nil
+	default:
+		logger.Error(err, msg)
+		return ctrl.Result{}, err
+	}
+}
+
 func (r *NodeReconciler) isControlPlaneNode(node *corev1.Node) bool {
 	if _, exists := node.Labels["node-role.kubernetes.io/control-plane"]; exists {
 		return true
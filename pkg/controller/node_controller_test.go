@@ -2,32 +2,81 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/rojanDinc/proxmox-name-sync-controller/pkg/proxmox"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 type MockProxmoxClient struct {
 	GetVMByUUIDFn  func(ctx context.Context, uuid string) (*proxmox.VM, error)
-	UpdateVMNameFn func(ctx context.Context, nodeName string, vmid int, newName string) error
+	GetVMByMACFn   func(ctx context.Context, macs []string) (*proxmox.VM, error)
+	GetVMByIDFn    func(ctx context.Context, cluster string, vmid int) (*proxmox.VM, error)
+	GetVMByAgentFn func(ctx context.Context, hostnames []string, ips []string) (*proxmox.VM, error)
+	// GetVMsByNameFn defaults to finding no VMs.
+	GetVMsByNameFn func(ctx context.Context, cluster string, name string) ([]proxmox.VM, error)
+	// UpdateVMDescriptionFn defaults to succeeding.
+	UpdateVMDescriptionFn func(ctx context.Context, vm *proxmox.VM, description string) error
+	GetVMByHostFn         func(ctx context.Context, hostnames []string) (*proxmox.VM, error)
+	UpdateVMNameFn        func(ctx context.Context, vm *proxmox.VM, newName string) error
 }
 
 func (mock *MockProxmoxClient) GetVMByUUID(ctx context.Context, uuid string) (*proxmox.VM, error) {
 	return mock.GetVMByUUIDFn(ctx, uuid)
 }
 
-func (mock *MockProxmoxClient) UpdateVMName(ctx context.Context, nodeName string, vmid int, newName string) error {
-	return mock.UpdateVMNameFn(ctx, nodeName, vmid, newName)
+func (mock *MockProxmoxClient) GetVMByMAC(ctx context.Context, macs []string) (*proxmox.VM, error) {
+	return mock.GetVMByMACFn(ctx, macs)
 }
 
+func (mock *MockProxmoxClient) GetVMByID(ctx context.Context, cluster string, vmid int) (*proxmox.VM, error) {
+	return mock.GetVMByIDFn(ctx, cluster, vmid)
+}
+
+func (mock *MockProxmoxClient) GetVMByGuestAgent(ctx context.Context, hostnames []string, ips []string) (*proxmox.VM, error) {
+	return mock.GetVMByAgentFn(ctx, hostnames, ips)
+}
+
+func (mock *MockProxmoxClient) GetVMByHostname(ctx context.Context, hostnames []string) (*proxmox.VM, error) {
+	return mock.GetVMByHostFn(ctx, hostnames)
+}
+
+func (mock *MockProxmoxClient) GetVMsByName(ctx context.Context, cluster string, name string) ([]proxmox.VM, error) {
+	if mock.GetVMsByNameFn == nil {
+		return nil, nil
+	}
+	return mock.GetVMsByNameFn(ctx, cluster, name)
+}
+
+func (mock *MockProxmoxClient) UpdateVMName(ctx context.Context, vm *proxmox.VM, newName string) error {
+	return mock.UpdateVMNameFn(ctx, vm, newName)
+}
+
+func (mock *MockProxmoxClient) UpdateVMDescription(ctx context.Context, vm *proxmox.VM, description string) error {
+	if mock.UpdateVMDescriptionFn == nil {
+		return nil
+	}
+	return mock.UpdateVMDescriptionFn(ctx, vm, description)
+}
+
+var (
+	errUnreachable = &proxmox.Error{Kind: proxmox.ErrorUnreachable, Err: errors.New("dial tcp: connection refused")}
+	errTaskFailed  = &proxmox.Error{Kind: proxmox.ErrorTaskFailed, Err: errors.New("unable to write config")}
+	errPartial     = &proxmox.PartialResultError{Nodes: []proxmox.NodeError{{Node: "pve-8", Err: errors.New("offline")}}}
+)
+
 func TestNodeReconciler_Reconcile_Scenarios(t *testing.T) {
 	scheme := runtime.NewScheme()
 	if err := corev1.AddToScheme(scheme); err != nil {
@@ -35,11 +84,12 @@ func TestNodeReconciler_Reconcile_Scenarios(t *testing.T) {
 	}
 
 	tests := []struct {
-		name            string
-		node            corev1.Node
-		expectedError   error
-		expectedNewName string
-		mock            *MockProxmoxClient
+		name                 string
+		node                 corev1.Node
+		expectedError        error
+		expectedNewName      string
+		expectedRequeueAfter time.Duration
+		mock                 *MockProxmoxClient
 	}{
 		{
 			name: "no update when VM name matches node name",
@@ -52,7 +102,7 @@ func TestNodeReconciler_Reconcile_Scenarios(t *testing.T) {
 				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
 					return &proxmox.VM{ID: 100, Name: "worker-01", Node: "pve-1", UUID: "uuid-1"}, nil
 				},
-				UpdateVMNameFn: func(ctx context.Context, nodeName string, vmid int, newName string) error {
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
 					return nil
 				},
 			},
@@ -69,7 +119,7 @@ func TestNodeReconciler_Reconcile_Scenarios(t *testing.T) {
 				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
 					return &proxmox.VM{ID: 200, Name: "old-name", Node: "pve-2", UUID: "uuid-2"}, nil
 				},
-				UpdateVMNameFn: func(ctx context.Context, nodeName string, vmid int, newName string) error {
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
 					return nil
 				},
 			},
@@ -83,7 +133,7 @@ func TestNodeReconciler_Reconcile_Scenarios(t *testing.T) {
 			expectedError: nil,
 			mock: &MockProxmoxClient{
 				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) { return nil, nil },
-				UpdateVMNameFn: func(ctx context.Context, nodeName string, vmid int, newName string) error {
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
 					return nil
 				},
 			},
@@ -94,10 +144,61 @@ func TestNodeReconciler_Reconcile_Scenarios(t *testing.T) {
 				ObjectMeta: testNodeMeta("worker-04"),
 				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-4"}},
 			},
-			expectedError: proxmoxInternalErr,
+			expectedError: errUnreachable,
+			mock: &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) { return nil, errUnreachable },
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+					return nil
+				},
+			},
+		},
+		{
+			name: "partial inventory with VM found on healthy node updates",
+			node: corev1.Node{
+				ObjectMeta: testNodeMeta("worker-07"),
+				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-7"}},
+			},
+			expectedNewName: "worker-07",
+			expectedError:   nil,
 			mock: &MockProxmoxClient{
-				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) { return nil, proxmoxInternalErr },
-				UpdateVMNameFn: func(ctx context.Context, nodeName string, vmid int, newName string) error {
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					return &proxmox.VM{ID: 700, Name: "old-name", Node: "pve-7", UUID: "uuid-7"},
+						&proxmox.PartialResultError{Nodes: []proxmox.NodeError{{Node: "pve-8", Err: errors.New("offline")}}}
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+					return nil
+				},
+			},
+		},
+		{
+			name: "partial inventory without VM bubbles up",
+			node: corev1.Node{
+				ObjectMeta: testNodeMeta("worker-08"),
+				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-8"}},
+			},
+			expectedError: errPartial,
+			mock: &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					return nil, errPartial
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+					return nil
+				},
+			},
+		},
+		{
+			name: "invalid credentials back off without error",
+			node: corev1.Node{
+				ObjectMeta: testNodeMeta("worker-09"),
+				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-9"}},
+			},
+			expectedError:        nil,
+			expectedRequeueAfter: authErrorRequeueDuration,
+			mock: &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					return nil, fmt.Errorf("failed to list VMs: %w", &proxmox.InvalidCredentialsError{Host: "https://pve-1:8006", Username: "root@pam"})
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
 					return nil
 				},
 			},
@@ -108,13 +209,68 @@ func TestNodeReconciler_Reconcile_Scenarios(t *testing.T) {
 				ObjectMeta: testNodeMeta("worker-05"),
 				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-5"}},
 			},
-			expectedError: proxmoxInternalErr,
+			expectedNewName: "worker-05",
+			expectedError:   errTaskFailed,
 			mock: &MockProxmoxClient{
 				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
 					return &proxmox.VM{ID: 500, Name: "wrong-name", Node: "pve-5", UUID: "uuid-5"}, nil
 				},
-				UpdateVMNameFn: func(ctx context.Context, nodeName string, vmid int, newName string) error {
-					return proxmoxInternalErr
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+					return errTaskFailed
+				},
+			},
+		},
+		{
+			name: "locked VM is retried later without error",
+			node: corev1.Node{
+				ObjectMeta: testNodeMeta("worker-10"),
+				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-10"}},
+			},
+			expectedNewName:      "worker-10",
+			expectedError:        nil,
+			expectedRequeueAfter: lockedRequeueDuration,
+			mock: &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					return &proxmox.VM{ID: 1000, Name: "old-name", Node: "pve-1", UUID: "uuid-10"}, nil
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+					return &proxmox.Error{Kind: proxmox.ErrorVMLocked, Err: errors.New("VM is locked (backup)")}
+				},
+			},
+		},
+		{
+			name: "lock cached in the inventory does not block the rename",
+			node: corev1.Node{
+				ObjectMeta: testNodeMeta("worker-12"),
+				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-12"}},
+			},
+			expectedNewName:      "worker-12",
+			expectedError:        nil,
+			expectedRequeueAfter: requeueDuration,
+			mock: &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					return &proxmox.VM{ID: 1200, Name: "old-name", Node: "pve-1", UUID: "uuid-12", Lock: "migrate"}, nil
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+					return nil
+				},
+			},
+		},
+		{
+			name: "missing privilege backs off without error",
+			node: corev1.Node{
+				ObjectMeta: testNodeMeta("worker-11"),
+				Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-11"}},
+			},
+			expectedNewName:      "worker-11",
+			expectedError:        nil,
+			expectedRequeueAfter: authErrorRequeueDuration,
+			mock: &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					return &proxmox.VM{ID: 1100, Name: "old-name", Node: "pve-1", UUID: "uuid-11"}, nil
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+					return &proxmox.Error{Kind: proxmox.ErrorForbidden, Err: errors.New("Permission check failed")}
 				},
 			},
 		},
@@ -133,7 +289,7 @@ func TestNodeReconciler_Reconcile_Scenarios(t *testing.T) {
 				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
 					return &proxmox.VM{ID: 600, Name: "different", Node: "pve-6", UUID: "uuid-cp"}, nil
 				},
-				UpdateVMNameFn: func(ctx context.Context, nodeName string, vmid int, newName string) error {
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
 					return nil
 				},
 			},
@@ -142,20 +298,123 @@ func TestNodeReconciler_Reconcile_Scenarios(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
+			var updatedName string
+			update := tc.mock.UpdateVMNameFn
+			tc.mock.UpdateVMNameFn = func(ctx context.Context, vm *proxmox.VM, newName string) error {
+				updatedName = newName
+				return update(ctx, vm, newName)
+			}
+
 			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tc.node.DeepCopy()).Build()
 			r := NewNodeReconciler(c, scheme, tc.mock)
 
 			req := ctrl.Request{NamespacedName: types.NamespacedName{Name: tc.node.Name}}
-			_, err := r.Reconcile(t.Context(), req)
+			res, err := r.Reconcile(t.Context(), req)
 
 			assert.Equal(t, tc.expectedError, err)
+			if tc.expectedRequeueAfter != 0 {
+				assert.Equal(t, tc.expectedRequeueAfter, res.RequeueAfter)
+			}
+			assert.Equal(t, tc.expectedNewName, updatedName)
 		})
 	}
 }
 
+func TestNodeReconciler_Reconcile_DuplicateUUID(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "worker-13",
+			Annotations: map[string]string{MACAddressesKey: "bc:24:11:2a:3b:4c"},
+		},
+		Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "uuid-13"}},
+	}
+	var macLookup bool
+	mock := &MockProxmoxClient{
+		GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+			return nil, &proxmox.Error{Kind: proxmox.ErrorDuplicateUUID, Err: &proxmox.DuplicateUUIDError{
+				UUID: uuid,
+				VMs:  []proxmox.VM{{ID: 1300, Node: "pve-1"}, {ID: 1301, Node: "pve-2"}},
+			}}
+		},
+		GetVMByMACFn: func(ctx context.Context, macs []string) (*proxmox.VM, error) {
+			macLookup = true
+			return nil, nil
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node.DeepCopy()).Build()
+	recorder := record.NewFakeRecorder(1)
+	r := NewNodeReconciler(c, scheme, mock)
+	r.Matchers = NewMatchers(mock, []MatchStrategy{MatchStrategyUUID, MatchStrategyMAC}, UUIDMatchExact)
+	r.Recorder = recorder
+
+	res, err := r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+	require.NoError(t, err)
+	assert.Equal(t, duplicateUUIDRequeueDuration, res.RequeueAfter)
+	assert.False(t, macLookup, "an ambiguous UUID does not fall back to other strategies")
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning DuplicateVMUUID")
+}
+
 func testNodeMeta(name string) metav1.ObjectMeta {
 	return metav1.ObjectMeta{
 		Name:   name,
 		Labels: map[string]string{},
 	}
 }
+
+func TestNodeReconciler_Reconcile_MixedEndianUUID(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	node := corev1.Node{
+		ObjectMeta: testNodeMeta("worker-01"),
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: "3B2C1D6F-594A-8F4E-9B7A-0C1D2E3F4A5B"}},
+	}
+
+	for _, tc := range []struct {
+		mode          UUIDMatchMode
+		wantMatchedBy string
+	}{
+		{mode: UUIDMatchExact},
+		{mode: UUIDMatchMixedEndian, wantMatchedBy: MatchedByMixedEndianUUID},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			var renamed *proxmox.VM
+			mock := &MockProxmoxClient{
+				GetVMByUUIDFn: func(ctx context.Context, uuid string) (*proxmox.VM, error) {
+					if uuid != "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b" {
+						return nil, nil
+					}
+					return &proxmox.VM{ID: 100, Name: "old-name", Node: "pve-1", UUID: uuid}, nil
+				},
+				UpdateVMNameFn: func(ctx context.Context, vm *proxmox.VM, newName string) error {
+					renamed = vm
+					return nil
+				},
+			}
+
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(node.DeepCopy()).Build()
+			r := NewNodeReconciler(c, scheme, mock)
+			r.Matchers = NewMatchers(mock, []MatchStrategy{MatchStrategyUUID}, tc.mode)
+
+			_, err := r.Reconcile(t.Context(), ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+			assert.NoError(t, err)
+
+			if tc.wantMatchedBy == "" {
+				assert.Nil(t, renamed)
+				return
+			}
+			if assert.NotNil(t, renamed) {
+				assert.Equal(t, tc.wantMatchedBy, renamed.MatchedBy)
+			}
+		})
+	}
+}
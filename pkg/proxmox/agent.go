@@ -0,0 +1,258 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultGuestAgentTimeout  = 2 * time.Second
+	defaultGuestAgentCacheTTL = 5 * time.Minute
+	// guestAgentFailureTTL is how long a failed agent query is remembered, so
+	// that VMs without a running agent are not queried on every lookup.
+	guestAgentFailureTTL = time.Minute
+)
+
+type GuestAgentConfig struct {
+	// Timeout bounds each query of a VM's QEMU guest agent. Proxmox only gives
+	// up on an agent that does not answer after several seconds, so keep it short.
+	Timeout Duration `json:"timeout"`
+	// CacheTTL is how long the hostname and addresses reported by an agent are reused.
+	CacheTTL Duration `json:"cacheTtl"`
+}
+
+func (c GuestAgentConfig) withDefaults() GuestAgentConfig {
+	c.Timeout.Duration = durationOrDefault(c.Timeout, defaultGuestAgentTimeout)
+	c.CacheTTL.Duration = durationOrDefault(c.CacheTTL, defaultGuestAgentCacheTTL)
+
+	return c
+}
+
+// GuestInfo is what the QEMU guest agent reports about a running guest.
+type GuestInfo struct {
+	Hostname string
+	IPs      []netip.Addr
+}
+
+// guestMatch ranks how well a guest agent's answer matches a node. An IP
+// address may be shared, reused or NATed, so a hostname match ranks higher.
+type guestMatch int
+
+const (
+	guestNoMatch guestMatch = iota
+	guestIPMatch
+	guestHostnameMatch
+)
+
+// match reports whether the guest has any of the hostnames, compared case
+// insensitively and without domain when only one side has one, or else any of
+// ips.
+func (g *GuestInfo) match(hostnames []string, ips []netip.Addr) guestMatch {
+	for _, hostname := range hostnames {
+		if hostname != "" && hostnamesEqual(g.Hostname, hostname) {
+			return guestHostnameMatch
+		}
+	}
+
+	for _, ip := range ips {
+		for _, guestIP := range g.IPs {
+			if guestIP == ip {
+				return guestIPMatch
+			}
+		}
+	}
+
+	return guestNoMatch
+}
+
+func hostnamesEqual(a, b string) bool {
+	if strings.EqualFold(a, b) {
+		return true
+	}
+	if strings.Contains(a, ".") == strings.Contains(b, ".") {
+		return false
+	}
+
+	shortA, _, _ := strings.Cut(a, ".")
+	shortB, _, _ := strings.Cut(b, ".")
+	return strings.EqualFold(shortA, shortB)
+}
+
+// agentEnabled parses the agent option of a VM config, e.g. "1" or
+// "enabled=1,fstrim_cloned_disks=1".
+func agentEnabled(value string) bool {
+	first, _, _ := strings.Cut(value, ",")
+	if key, enabled, ok := strings.Cut(first, "="); ok {
+		return key == "enabled" && enabled == "1"
+	}
+
+	return first == "1"
+}
+
+type cachedGuestInfo struct {
+	info      *GuestInfo
+	expiresAt time.Time
+}
+
+// guestAgentCache remembers agent answers per VM id.
+type guestAgentCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[int]cachedGuestInfo
+}
+
+func newGuestAgentCache(ttl time.Duration) *guestAgentCache {
+	return &guestAgentCache{ttl: ttl, entries: make(map[int]cachedGuestInfo)}
+}
+
+func (c *guestAgentCache) get(vmid int) (*GuestInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[vmid]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+
+	return entry.info, true
+}
+
+func (c *guestAgentCache) put(vmid int, info *GuestInfo, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[vmid] = cachedGuestInfo{info: info, expiresAt: time.Now().Add(ttl)}
+}
+
+// GetVMByGuestAgent returns the running VM with the guest agent enabled whose
+// agent reports any of hostnames or, failing that, any of ips. When several
+// VMs match equally well, it fails with ErrorAmbiguousMatch. Agents that do
+// not answer within the configured timeout are skipped. Addresses that do not
+// parse are ignored.
+func (c *ClientPool) GetVMByGuestAgent(ctx context.Context, hostnames []string, ips []string) (*VM, error) {
+	var addrs []netip.Addr
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+
+	vms, err := c.inventory.all(ctx)
+	var partial *PartialResultError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, classify(err)
+	}
+
+	var candidates []VM
+	for _, vm := range vms {
+		if vm.GuestAgent && vm.Status == guestStatusRunning {
+			candidates = append(candidates, vm)
+		}
+	}
+
+	infos := make([]*GuestInfo, len(candidates))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency.Global)
+	for i, vm := range candidates {
+		g.Go(func() error {
+			infos[i] = c.guestInfo(gctx, vm)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	best := guestNoMatch
+	var matches []VM
+	for i, info := range infos {
+		if info == nil {
+			continue
+		}
+		switch rank := info.match(hostnames, addrs); {
+		case rank > best:
+			best = rank
+			matches = []VM{candidates[i]}
+		case rank == best && rank != guestNoMatch:
+			matches = append(matches, candidates[i])
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, classify(err)
+	case 1:
+		return &matches[0], classify(err)
+	default:
+		ids := make([]int, 0, len(matches))
+		for _, vm := range matches {
+			ids = append(ids, vm.ID)
+		}
+		return nil, &Error{Kind: ErrorAmbiguousMatch, Err: fmt.Errorf("guest agents of VMs %v report the same node", ids)}
+	}
+}
+
+// guestInfo returns the cached or freshly queried agent answer of vm, or nil
+// when the agent did not answer.
+func (c *ClientPool) guestInfo(ctx context.Context, vm VM) *GuestInfo {
+	if info, ok := c.agentCache.get(vm.ID); ok {
+		return info
+	}
+
+	info, err := c.queryGuestAgent(ctx, vm)
+	if err != nil {
+		slog.Debug("Failed to query QEMU guest agent", "vmid", vm.ID, "node", vm.Node, "error", err)
+		c.agentCache.put(vm.ID, nil, min(guestAgentFailureTTL, c.agentCache.ttl))
+		return nil
+	}
+
+	c.agentCache.put(vm.ID, info, c.agentCache.ttl)
+	return info
+}
+
+func (c *ClientPool) queryGuestAgent(ctx context.Context, vm VM) (*GuestInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.guestAgent.Timeout.Duration)
+	defer cancel()
+
+	client, err := c.getClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+
+	var hostname struct {
+		Result struct {
+			HostName string `json:"host-name"`
+		} `json:"result"`
+	}
+	if err := client.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/get-host-name", vm.Node, vm.ID), &hostname); err != nil {
+		return nil, fmt.Errorf("failed to get hostname of VM %d: %w", vm.ID, err)
+	}
+
+	var ifaces struct {
+		Result []*proxmox.AgentNetworkIface `json:"result"`
+	}
+	if err := client.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/network-get-interfaces", vm.Node, vm.ID), &ifaces); err != nil {
+		return nil, fmt.Errorf("failed to get network interfaces of VM %d: %w", vm.ID, err)
+	}
+
+	info := &GuestInfo{Hostname: hostname.Result.HostName}
+	for _, iface := range ifaces.Result {
+		for _, ip := range iface.IPAddresses {
+			addr, err := netip.ParseAddr(ip.IPAddress)
+			if err != nil || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+				continue
+			}
+			info.IPs = append(info.IPs, addr.Unmap())
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,107 @@
+package proxmox
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentEnabled(t *testing.T) {
+	for value, want := range map[string]bool{
+		"":                                false,
+		"0":                               false,
+		"1":                               true,
+		"1,fstrim_cloned_disks=1":         true,
+		"enabled=1,type=virtio":           true,
+		"enabled=0":                       false,
+		"fstrim_cloned_disks=1,enabled=1": false,
+		"type=isa,fstrim_cloned_disks=1":  false,
+	} {
+		assert.Equal(t, want, agentEnabled(value), value)
+	}
+}
+
+func TestClientPool_GetVMByGuestAgent(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100), Agent: "1", AgentHangs: true})
+	f.addVM("pve-1", fakeVM{ID: 101, Name: "vm-101", SMBIOS: "uuid=" + testUUID(101)})
+	f.addVM("pve-1", fakeVM{ID: 102, Name: "vm-102", SMBIOS: "uuid=" + testUUID(102), Agent: "1", Stopped: true,
+		Hostname: "worker-02"})
+	f.addVM("pve-2", fakeVM{ID: 200, Name: "vm-200", SMBIOS: "uuid=" + testUUID(200), Agent: "enabled=1",
+		Hostname: "worker-01.example.com", IPs: []string{"10.0.0.5", "fe80::1"}})
+	pool := newTestClientPool(t, f)
+	pool.guestAgent.Timeout.Duration = 100 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		hostnames []string
+		ips       []string
+		wantID    int
+	}{
+		{name: "hostname", hostnames: []string{"WORKER-01.example.com"}, wantID: 200},
+		{name: "short hostname", hostnames: []string{"worker-01"}, wantID: 200},
+		{name: "other domain", hostnames: []string{"worker-01.example.org"}},
+		{name: "ip", hostnames: []string{"worker-09"}, ips: []string{"10.0.0.5"}, wantID: 200},
+		{name: "loopback is ignored", ips: []string{"127.0.0.1"}},
+		{name: "no match", hostnames: []string{"worker-02"}, ips: []string{"10.0.0.6", "not-an-ip"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vm, err := pool.GetVMByGuestAgent(t.Context(), tc.hostnames, tc.ips)
+			require.NoError(t, err)
+			if tc.wantID == 0 {
+				assert.Nil(t, vm)
+				return
+			}
+			require.NotNil(t, vm)
+			assert.Equal(t, tc.wantID, vm.ID)
+		})
+	}
+
+	assert.Equal(t, int64(0), f.requestCount("/nodes/pve-1/qemu/101/agent/get-host-name"), "VMs without agent are not queried")
+	assert.Equal(t, int64(0), f.requestCount("/nodes/pve-1/qemu/102/agent/get-host-name"), "stopped VMs are not queried")
+	assert.Equal(t, int64(1), f.requestCount("/nodes/pve-1/qemu/100/agent/get-host-name"), "failed agent is not queried again")
+	assert.Equal(t, int64(1), f.requestCount("/nodes/pve-2/qemu/200/agent/get-host-name"), "answers are cached")
+}
+
+func TestClientPool_GetVMByGuestAgent_Ranking(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100), Agent: "1",
+		Hostname: "nat-gw", IPs: []string{"10.0.0.5"}})
+	f.addVM("pve-1", fakeVM{ID: 101, Name: "vm-101", SMBIOS: "uuid=" + testUUID(101), Agent: "1",
+		Hostname: "worker-01", IPs: []string{"10.0.0.6"}})
+	f.addVM("pve-1", fakeVM{ID: 102, Name: "vm-102", SMBIOS: "uuid=" + testUUID(102), Agent: "1",
+		Hostname: "worker-02", IPs: []string{"10.0.0.5"}})
+	pool := newTestClientPool(t, f)
+
+	vm, err := pool.GetVMByGuestAgent(t.Context(), []string{"worker-01"}, []string{"10.0.0.5"})
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, 101, vm.ID, "a hostname match beats an earlier IP match")
+
+	vm, err = pool.GetVMByGuestAgent(t.Context(), []string{"worker-09"}, []string{"10.0.0.5"})
+	assert.Nil(t, vm)
+	assert.Equal(t, ErrorAmbiguousMatch, KindOf(err))
+}
+
+func TestClientPool_GetVMByGuestAgent_HungAgents(t *testing.T) {
+	f := newFakeProxmox(t)
+	for id := range 4 {
+		f.addVM("pve-1", fakeVM{ID: 100 + id, Name: fmt.Sprintf("vm-%d", 100+id), SMBIOS: "uuid=" + testUUID(100+id),
+			Agent: "1", AgentHangs: true})
+	}
+	pool := newTestClientPool(t, f)
+	pool.guestAgent.Timeout.Duration = 50 * time.Millisecond
+	pool.hosts[0].failureThreshold = 1
+
+	vm, err := pool.GetVMByGuestAgent(t.Context(), []string{"worker-01"}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, vm)
+
+	_, err = pool.getClient(t.Context())
+	assert.NoError(t, err, "agents that time out do not take the host out of rotation")
+}
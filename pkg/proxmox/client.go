@@ -2,141 +2,375 @@ package proxmox
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
-	"net/http"
-	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/luthermonson/go-proxmox"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
 	taskInterval = 5 * time.Second
 	taskTimeout  = 30 * time.Second
-)
 
-type Config ClusterConfig
+	nodeStatusOffline = "offline"
+)
 
-// TODO: Change this to a slice so that each hosturl can be customized.
 type ClusterConfig struct {
+	// Name identifies the cluster when more than one is configured.
+	Name string `json:"name,omitempty"`
+	// Hosts lists the API endpoints of the cluster with per-host settings.
+	Hosts []HostConfig `json:"hosts,omitempty"`
+	// HostURLs is the flat list of endpoints that share the cluster level
+	// settings below. It can be combined with Hosts.
 	HostURLs []string `json:"hostUrls"`
 	Username string   `json:"username"`
 	Password string   `json:"password"`
-	TokenID  string   `json:"tokenId"`
-	Secret   string   `json:"secret"`
-	Insecure bool     `json:"insecure"`
+	// TOTPSecret is the base32 TOTP secret of the user, required when the
+	// user has two-factor authentication enabled.
+	TOTPSecret string `json:"totpSecret,omitempty"`
+	TokenID    string `json:"tokenId"`
+	Secret     string `json:"secret"`
+	Insecure   bool   `json:"insecure"`
+	// CABundle and CABundleFile set the trusted CA certificates of every host
+	// that does not configure its own.
+	CABundle     string `json:"caBundle,omitempty"`
+	CABundleFile string `json:"caBundleFile,omitempty"`
+
+	// SelectionPolicy decides which available host serves a call: "ordered"
+	// (default), "round-robin" or "lowest-latency".
+	SelectionPolicy SelectionPolicy   `json:"selectionPolicy,omitempty"`
+	HealthCheck     HealthCheckConfig `json:"healthCheck"`
+	// RateLimit is shared by all hosts of the cluster.
+	RateLimit RateLimitConfig `json:"rateLimit"`
+	// Retry controls how transient failures of reads and renames are retried.
+	Retry RetryConfig `json:"retry"`
+
+	Inventory   InventoryConfig   `json:"inventory"`
+	Concurrency ConcurrencyConfig `json:"concurrency"`
+	GuestAgent  GuestAgentConfig  `json:"guestAgent"`
 }
 
 type ClientPool struct {
-	clients []*proxmox.Client
+	name            string
+	hosts           []*host
+	selectionPolicy SelectionPolicy
+	roundRobin      atomic.Uint64
+	healthCheck     HealthCheckConfig
+	retry           RetryConfig
+	inventory       *inventory
+	discovery       *resourceDiscovery
+	concurrency     ConcurrencyConfig
+	guestAgent      GuestAgentConfig
+	agentCache      *guestAgentCache
+	// includeTemplates makes templates available to lookups.
+	includeTemplates bool
 }
 
+// GuestType is the kind of Proxmox guest backing a node.
+type GuestType string
+
+const (
+	GuestTypeQEMU GuestType = "qemu"
+	GuestTypeLXC  GuestType = "lxc"
+)
+
 type VM struct {
-	ID   int
-	Name string
-	Node string
+	ID        int
+	Name      string
+	Node      string
+	GuestType GuestType
+	// UUID is the SMBIOS UUID of a QEMU VM. Containers have none.
 	UUID string
+	// Hostname is the configured hostname of a container. QEMU VMs have none.
+	Hostname string
+	// Cluster is the name of the Proxmox cluster the VM belongs to.
+	Cluster string
+	// MACs are the MAC addresses of the VM's network devices, normalized by NormalizeMAC.
+	MACs []string
+	// GuestAgent is set when the QEMU guest agent is enabled in the VM config.
+	GuestAgent bool
+	// Template is set for VM and container templates.
+	Template bool
+	// Status is the run state reported by Proxmox, e.g. "running" or "stopped".
+	Status string
+	// Lock is the lock on the guest's config when it was last listed, e.g.
+	// "backup" or "migrate". It is empty when the guest is not locked. It may
+	// be outdated, so renames do not check it; renaming a locked guest fails
+	// with ErrorVMLocked.
+	Lock string
+	// Description is the guest's notes when its config was last read.
+	Description string
+	// MatchedBy records how the VM was matched to a Kubernetes node, e.g.
+	// "provider-id", "uuid" or "mac". It is set by the caller doing the match.
+	MatchedBy string
 }
 
-func NewClient(clusterConfig *ClusterConfig) (*ClientPool, error) {
-	clientPool := &ClientPool{clients: make([]*proxmox.Client, 0)}
-	for _, hostURL := range clusterConfig.HostURLs {
-		parsedURL, err := url.Parse(hostURL)
-		if err != nil {
-			return nil, fmt.Errorf("invalid Proxmox URL: %w", err)
-		}
-
-		httpClient := &http.Client{}
-		if clusterConfig.Insecure {
-			httpClient.Transport = &http.Transport{
-				// #nosec G402
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			}
-		}
+func NewClientPool(clusterConfig *ClusterConfig) (*ClientPool, error) {
+	clientPool := &ClientPool{
+		name:            clusterConfig.Name,
+		selectionPolicy: clusterConfig.SelectionPolicy,
+		healthCheck:     clusterConfig.HealthCheck.withDefaults(),
+		retry:           clusterConfig.Retry.withDefaults(),
+		concurrency:     clusterConfig.Concurrency.withDefaults(),
+		guestAgent:      clusterConfig.GuestAgent.withDefaults(),
 
-		var client *proxmox.Client
-
-		if clusterConfig.TokenID != "" && clusterConfig.Secret != "" {
-			client = proxmox.NewClient(parsedURL.String(),
-				proxmox.WithAPIToken(clusterConfig.TokenID, clusterConfig.Secret),
-				proxmox.WithHTTPClient(httpClient),
-			)
-		} else if clusterConfig.Username != "" && clusterConfig.Password != "" {
-			credentials := &proxmox.Credentials{
-				Username: clusterConfig.Username,
-				Password: clusterConfig.Password,
-			}
-			client = proxmox.NewClient(parsedURL.String(),
-				proxmox.WithCredentials(credentials),
-				proxmox.WithHTTPClient(httpClient),
-			)
-		} else {
-			return nil, fmt.Errorf("either API token (TokenID and Secret) or credentials (Username and Password) must be provided")
+		includeTemplates: clusterConfig.Inventory.IncludeTemplates,
+	}
+	clusterLimiter := clusterConfig.RateLimit.newLimiter()
+	for _, hostConfig := range clusterConfig.EffectiveHosts() {
+		h, err := newHost(clusterConfig.Name, hostConfig, clientPool.healthCheck, clusterLimiter)
+		if err != nil {
+			return nil, err
 		}
 
-		if client != nil {
-			clientPool.clients = append(clientPool.clients, client)
-		}
+		clientPool.hosts = append(clientPool.hosts, h)
 	}
 
+	clientPool.discovery = newResourceDiscovery(clusterConfig.Name, clusterConfig.Inventory, clientPool.retry)
+	clientPool.inventory = newInventory(clusterConfig.Inventory, clientPool.listVMs)
+	clientPool.agentCache = newGuestAgentCache(clientPool.guestAgent.CacheTTL.Duration)
+
 	return clientPool, nil
 }
 
+// Start keeps the VM inventory refreshed and the hosts health checked until
+// ctx is cancelled.
+func (c *ClientPool) Start(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error { return c.runHealthChecks(gctx) })
+	g.Go(func() error { return c.inventory.run(gctx) })
+
+	return g.Wait()
+}
+
+// RefreshInventory re-reads the VM inventory from Proxmox immediately.
+func (c *ClientPool) RefreshInventory(ctx context.Context) error {
+	return c.inventory.refresh(ctx)
+}
+
+// GetVMs lists the VMs of every online node concurrently, bounded by the
+// configured global and per-node limits. VMs are returned in node order and,
+// within a node, in the order Proxmox lists them. Nodes reported offline are
+// skipped; if any other node fails, the VMs of the healthy nodes are returned
+// together with a *PartialResultError.
 func (c *ClientPool) GetVMs(ctx context.Context) ([]VM, error) {
-	client, err := c.getClient(ctx)
+	var client *proxmox.Client
+	var nodes proxmox.NodeStatuses
+	err := c.retry.do(ctx, "list nodes", func() error {
+		var err error
+		client, err = c.getClient(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get client: %w", err)
+		}
+
+		nodes, err = client.Nodes(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get nodes: %w", err)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get client: %w", err)
+		return nil, classify(err)
 	}
 
-	nodes, err := client.Nodes(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get nodes: %w", err)
+	global := newSemaphore(c.concurrency.Global)
+	results := make([][]VM, len(nodes))
+	errs := make([]error, len(nodes))
+
+	var wg sync.WaitGroup
+	for i, nodeStatus := range nodes {
+		if nodeStatus.Status == nodeStatusOffline {
+			slog.Info("Skipping offline node", "node", nodeStatus.Node)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vms, err := c.getNodeVMs(ctx, client, nodeStatus.Node, global)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			containers, err := c.getNodeContainers(ctx, client, nodeStatus.Node, global)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i] = append(vms, containers...)
+		}()
 	}
+	wg.Wait()
 
 	var allVMs []VM
-	for _, nodeStatus := range nodes {
-		node, err := client.Node(ctx, nodeStatus.Node)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get node %s: %w", nodeStatus.Node, err)
+	var nodeErrs []NodeError
+	for i, vms := range results {
+		if errs[i] != nil {
+			slog.Warn("Failed to list VMs of node", "node", nodes[i].Node, "error", errs[i])
+			nodeErrs = append(nodeErrs, NodeError{Node: nodes[i].Node, Err: classify(errs[i])})
+			continue
 		}
+		allVMs = append(allVMs, vms...)
+	}
 
-		vms, err := node.VirtualMachines(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get VMs from node %s: %w", nodeStatus.Node, err)
-		}
+	return allVMs, partialResult(nodeErrs)
+}
+
+func (c *ClientPool) getNodeVMs(ctx context.Context, client *proxmox.Client, nodeName string, global semaphore) ([]VM, error) {
+	var node *proxmox.Node
+	var vms proxmox.VirtualMachines
+	err := c.retry.do(ctx, "list node VMs", func() error {
+		return global.do(ctx, func() error {
+			var err error
+			node, err = client.Node(ctx, nodeName)
+			if err != nil {
+				return fmt.Errorf("failed to get node %s: %w", nodeName, err)
+			}
 
-		for _, partialVM := range vms {
-			vm, err := node.VirtualMachine(ctx, int(partialVM.VMID))
+			vms, err = node.VirtualMachines(ctx)
 			if err != nil {
-				return nil, err
+				return fmt.Errorf("failed to get VMs from node %s: %w", nodeName, err)
 			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*VM, len(vms))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency.PerNode)
+	for i, partialVM := range vms {
+		g.Go(func() error {
+			var vm *proxmox.VirtualMachine
+			err := c.retry.do(gctx, "get VM", func() error {
+				return global.do(gctx, func() error {
+					var err error
+					vm, err = node.VirtualMachine(gctx, int(partialVM.VMID))
+					return err
+				})
+			})
+			if err != nil {
+				return err
+			}
+
 			if vm.VirtualMachineConfig == nil {
-				slog.Info("Skipping VM with nil configuration", "vmid", vm.VMID, "node", nodeStatus.Node)
-				continue
+				slog.Info("Skipping VM with nil configuration", "vmid", vm.VMID, "node", nodeName)
+				return nil
 			}
-			ok, uuid := extractUUIDFrom(vm.VirtualMachineConfig.SMBios1)
-			if !ok {
-				slog.Info("Skipping VM with no uuid", "vmid", vm.VMID, "node", nodeStatus.Node)
-				continue
+
+			result := newQEMUVM(c.name, nodeName, int(vm.VMID), vm.Name, vm.VirtualMachineConfig)
+			result.Status = vm.Status
+			if !result.matchable() {
+				slog.Info("Skipping VM with no uuid and no MAC address", "vmid", vm.VMID, "node", nodeName)
+				return nil
 			}
+			results[i] = &result
+			return nil
+		})
+	}
 
-			allVMs = append(allVMs, VM{
-				ID:   int(vm.VMID),
-				Name: vm.Name,
-				Node: nodeStatus.Node,
-				UUID: uuid,
-			})
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	nodeVMs := make([]VM, 0, len(results))
+	for _, vm := range results {
+		if vm != nil {
+			nodeVMs = append(nodeVMs, *vm)
 		}
 	}
 
-	return allVMs, nil
+	return nodeVMs, nil
+}
+
+// newQEMUVM builds the VM from its config. Invalid smbios1 fields other than
+// the UUID do not stop the VM from being matched by UUID, and VMs without a
+// usable UUID can still be matched by MAC address.
+func newQEMUVM(cluster, nodeName string, vmid int, name string, config *proxmox.VirtualMachineConfig) VM {
+	smbios, err := ParseSMBIOS(config.SMBios1)
+	if err != nil {
+		slog.Warn("Ignoring invalid smbios1 fields of VM", "vmid", vmid, "node", nodeName, "error", err)
+	}
+
+	return VM{
+		ID:          vmid,
+		Name:        name,
+		Node:        nodeName,
+		GuestType:   GuestTypeQEMU,
+		UUID:        smbios.UUID,
+		Cluster:     cluster,
+		MACs:        vmMACAddresses(config),
+		GuestAgent:  agentEnabled(config.Agent),
+		Template:    config.Template == 1,
+		Lock:        config.Lock,
+		Description: config.Description,
+	}
+}
+
+// applyConfig records a config change made by the controller on vm.
+func (vm *VM) applyConfig(key, value string) {
+	switch key {
+	case "name":
+		vm.Name = value
+	case "hostname":
+		vm.Name = value
+		vm.Hostname = strings.ToLower(value)
+	case "description":
+		vm.Description = value
+	}
+}
+
+// matchable reports whether any matcher could find vm.
+func (vm *VM) matchable() bool {
+	return vm.UUID != "" || vm.Hostname != "" || len(vm.MACs) > 0
 }
 
+// listVMs discovers VMs through /cluster/resources and falls back to walking
+// every node with GetVMs when that fails.
+func (c *ClientPool) listVMs(ctx context.Context) ([]VM, error) {
+	client, err := c.getClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+
+	vms, err := c.discovery.discover(ctx, client, c.concurrency)
+	var partial *PartialResultError
+	if err == nil || errors.As(err, &partial) {
+		return vms, err
+	}
+
+	slog.Warn("Cluster resource discovery failed, falling back to per-node listing", "error", err)
+	return c.GetVMs(ctx)
+}
+
+// UpdateVMName renames the VM and waits for the config task to finish.
+// Failures are returned as *Error.
 func (c *ClientPool) UpdateVMName(ctx context.Context, nodeName string, vmid int, newName string) error {
+	// Setting the name is idempotent, so the whole update can be retried.
+	return classify(c.retry.do(ctx, "update VM name", func() error {
+		return c.updateVMConfig(ctx, nodeName, vmid, proxmox.VirtualMachineOption{Name: "name", Value: newName})
+	}))
+}
+
+// UpdateVMDescription replaces the description of the VM like UpdateVMName.
+func (c *ClientPool) UpdateVMDescription(ctx context.Context, nodeName string, vmid int, description string) error {
+	return classify(c.retry.do(ctx, "update VM description", func() error {
+		return c.updateVMConfig(ctx, nodeName, vmid, proxmox.VirtualMachineOption{Name: "description", Value: description})
+	}))
+}
+
+func (c *ClientPool) updateVMConfig(ctx context.Context, nodeName string, vmid int, option proxmox.VirtualMachineOption) error {
 	client, err := c.getClient(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get client: %w", err)
@@ -152,59 +386,143 @@ func (c *ClientPool) UpdateVMName(ctx context.Context, nodeName string, vmid int
 		return fmt.Errorf("failed to get VM %d on node %s: %w", vmid, nodeName, err)
 	}
 
-	task, err := vm.Config(ctx, proxmox.VirtualMachineOption{
-		Name:  "name",
-		Value: newName,
-	})
+	task, err := vm.Config(ctx, option)
 	if err != nil {
-		return fmt.Errorf("failed to update VM %d name: %w", vmid, err)
+		return fmt.Errorf("failed to update VM %d %s: %w", vmid, option.Name, err)
 	}
 
 	if err := task.Wait(ctx, taskInterval, taskTimeout); err != nil {
-		return fmt.Errorf("failed to wait for VM %d name update task: %w", vmid, err)
+		return fmt.Errorf("failed to wait for VM %d %s update task: %w", vmid, option.Name, err)
 	}
+	// Wait only reports whether the task stopped, not whether it succeeded.
+	if task.IsFailed {
+		return &Error{Kind: ErrorTaskFailed, Err: fmt.Errorf("VM %d %s update task %s: %s", vmid, option.Name, task.UPID, task.ExitStatus)}
+	}
+
+	c.discovery.evict(vmid)
+	value, _ := option.Value.(string)
+	c.inventory.invalidate(nodeName, vmid, func(vm *VM) { vm.applyConfig(option.Name, value) })
 
 	return nil
 }
 
 func (c *ClientPool) GetVMByUUID(ctx context.Context, uuid string) (*VM, error) {
-	vms, err := c.GetVMs(ctx)
+	if normalized, err := NormalizeUUID(uuid); err == nil {
+		uuid = normalized
+	}
+
+	vm, err := c.inventory.getByUUID(ctx, uuid)
+	return vm, classify(err)
+}
+
+// GetVMByID returns the VM or container with vmid from the inventory, or
+// reads it directly from Proxmox when the inventory does not have it. It
+// returns nil when the cluster has no such guest, or it is a template and
+// templates are not included.
+func (c *ClientPool) GetVMByID(ctx context.Context, vmid int) (*VM, error) {
+	if vm, _ := c.inventory.get(ctx, idKey(vmid)); vm != nil {
+		return vm, nil
+	}
+
+	var client *proxmox.Client
+	var resource *clusterResource
+	err := c.retry.do(ctx, "get cluster resources", func() error {
+		var err error
+		client, err = c.getClient(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get client: %w", err)
+		}
+
+		guests, err := getClusterGuests(ctx, client)
+		if err != nil {
+			return err
+		}
+
+		resource = nil
+		for _, guest := range guests {
+			if int(guest.VMID) == vmid {
+				resource = guest
+				break
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, classify(err)
+	}
+	if resource == nil {
+		return nil, nil
 	}
 
-	for _, vm := range vms {
-		slog.Debug("found vm", "id", vm.UUID)
-		if vm.UUID == uuid {
-			return &vm, nil
+	var vm VM
+	err = c.retry.do(ctx, "get guest config", func() error {
+		var err error
+		vm, _, err = fetchGuest(ctx, client, c.name, resource)
+		return err
+	})
+	if err != nil {
+		return nil, classify(err)
+	}
+	if vm.Template && !c.includeTemplates {
+		slog.Info("Ignoring template", "vmid", vmid, "node", vm.Node)
+		return nil, nil
+	}
+
+	return &vm, nil
+}
+
+// GetVMByMAC returns the VM owning any of macs, trying them in order.
+// Addresses that are not valid MACs are ignored.
+func (c *ClientPool) GetVMByMAC(ctx context.Context, macs []string) (*VM, error) {
+	var keys []string
+	for _, mac := range macs {
+		if normalized, err := NormalizeMAC(mac); err == nil {
+			keys = append(keys, macKey(normalized))
 		}
 	}
 
-	return nil, nil
+	return c.getByAnyKey(ctx, keys)
 }
 
-func (c *ClientPool) getClient(ctx context.Context) (*proxmox.Client, error) {
-	var errs []error
+// GetVMsByName returns the VMs and containers named name. The inventory is
+// scanned, so names need not be unique.
+func (c *ClientPool) GetVMsByName(ctx context.Context, name string) ([]VM, error) {
+	vms, err := c.inventory.all(ctx)
+	var partial *PartialResultError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, classify(err)
+	}
 
-	for _, client := range c.clients {
-		if _, err := client.Version(ctx); err != nil {
-			errs = append(errs, err)
-			continue
+	var named []VM
+	for _, vm := range vms {
+		if vm.Name == name {
+			named = append(named, vm)
 		}
-
-		return client, nil
 	}
 
-	return nil, errors.Join(errs...)
+	return named, classify(err)
 }
 
-func extractUUIDFrom(smbios string) (bool, string) {
-	splits := strings.SplitSeq(smbios, ",")
-	for split := range splits {
-		if strings.Contains(split, "uuid=") {
-			return true, strings.Split(split, "uuid=")[1]
+// getByAnyKey returns the VM of the first inventory key that has one. A
+// partial inventory does not stop the search, but is reported when nothing
+// is found.
+func (c *ClientPool) getByAnyKey(ctx context.Context, keys []string) (*VM, error) {
+	var partialErr error
+	for _, key := range keys {
+		vm, err := c.inventory.get(ctx, key)
+		if vm != nil {
+			return vm, classify(err)
+		}
+		var partial *PartialResultError
+		if errors.As(err, &partial) {
+			partialErr = err
+			continue
+		}
+		if err != nil {
+			return nil, classify(err)
 		}
 	}
 
-	return false, ""
+	return nil, partialErr
 }
@@ -0,0 +1,114 @@
+package proxmox
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPool_GetVMs_DeterministicOrder(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.latency = time.Millisecond
+	var expected []VM
+	for _, node := range []string{"pve-1", "pve-2", "pve-3"} {
+		for i := range 5 {
+			id := 100*len(expected) + i
+			uuid := testUUID(id)
+			f.addVM(node, fakeVM{ID: id, Name: fmt.Sprintf("vm-%d", id), SMBIOS: "uuid=" + uuid})
+			expected = append(expected, VM{ID: id, Name: fmt.Sprintf("vm-%d", id), Node: node, GuestType: GuestTypeQEMU, UUID: uuid, Cluster: "test", Status: "running"})
+		}
+	}
+	pool := newTestClientPool(t, f)
+	pool.concurrency = ConcurrencyConfig{Global: 8, PerNode: 3}
+
+	for range 3 {
+		vms, err := pool.GetVMs(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, expected, vms)
+	}
+}
+
+func TestClientPool_GetVMs_PartialResult(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+	f.addVM("pve-2", fakeVM{ID: 200, Name: "vm-200", SMBIOS: "uuid=" + testUUID(200)})
+	f.addVM("pve-3", fakeVM{ID: 300, Name: "vm-300", SMBIOS: "uuid=" + testUUID(300)})
+	f.offline["pve-2"] = true
+	f.failing["pve-3"] = true
+	pool := newTestClientPool(t, f)
+
+	vms, err := pool.GetVMs(t.Context())
+	assert.Equal(t, []VM{{ID: 100, Name: "vm-100", Node: "pve-1", GuestType: GuestTypeQEMU, UUID: testUUID(100), Cluster: "test", Status: "running"}}, vms)
+
+	var partial *PartialResultError
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, []string{"pve-3"}, partial.FailedNodes(), "offline nodes are skipped, not reported")
+
+	// Lookups accept UUIDs in any notation.
+	vm, err := pool.GetVMByUUID(t.Context(), "{"+testUUID(100)+"}")
+	require.ErrorAs(t, err, &partial)
+	require.NotNil(t, vm)
+	assert.Equal(t, 100, vm.ID)
+}
+
+func TestClientPool_GetVMByMAC(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100), Net0: "virtio=BC:24:11:00:01:00,bridge=vmbr0"})
+	// VMs without a UUID can only be found by MAC address.
+	f.addVM("pve-1", fakeVM{ID: 101, Name: "vm-101", Net0: "virtio=BC:24:11:00:01:01,bridge=vmbr0,firewall=1"})
+	pool := newTestClientPool(t, f)
+
+	vm, err := pool.GetVMByMAC(t.Context(), []string{"not-a-mac", "bc-24-11-00-01-01"})
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, 101, vm.ID)
+	assert.Empty(t, vm.UUID)
+	assert.Equal(t, []string{"bc:24:11:00:01:01"}, vm.MACs)
+
+	vm, err = pool.GetVMByMAC(t.Context(), []string{"bc:24:11:00:02:00"})
+	require.NoError(t, err)
+	assert.Nil(t, vm)
+}
+
+func TestClientPool_GetVMByUUID_InvalidSMBIOSField(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100) + ",serial=%%%,base64=1"})
+	pool := newTestClientPool(t, f)
+
+	vm, err := pool.GetVMByUUID(t.Context(), testUUID(100))
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, 100, vm.ID)
+}
+
+func BenchmarkClientPool_GetVMs(b *testing.B) {
+	for _, bc := range []struct {
+		name        string
+		concurrency ConcurrencyConfig
+	}{
+		{name: "sequential", concurrency: ConcurrencyConfig{Global: 1, PerNode: 1}},
+		{name: "global=16,perNode=4", concurrency: ConcurrencyConfig{Global: 16, PerNode: 4}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			f := newFakeProxmox(b)
+			f.latency = 2 * time.Millisecond
+			for n := range 4 {
+				for i := range 10 {
+					id := 100*n + i
+					f.addVM(fmt.Sprintf("pve-%d", n), fakeVM{ID: id, Name: fmt.Sprintf("vm-%d", id), SMBIOS: "uuid=" + testUUID(id)})
+				}
+			}
+			pool := newTestClientPool(b, f)
+			pool.concurrency = bc.concurrency
+
+			for b.Loop() {
+				if _, err := pool.GetVMs(b.Context()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultClusterName = "default"
+
+// Config is the Proxmox configuration of the controller. The embedded
+// ClusterConfig describes a single cluster and is kept for backwards
+// compatibility; Clusters lists any number of independent, named clusters.
+type Config struct {
+	ClusterConfig
+
+	Clusters []ClusterConfig `json:"clusters,omitempty"`
+}
+
+// EffectiveClusters returns every configured cluster. The top level cluster
+// is only included when it has at least one host and is named "default"
+// unless a name is set.
+func (c *Config) EffectiveClusters() []ClusterConfig {
+	clusters := make([]ClusterConfig, 0, len(c.Clusters)+1)
+	if len(c.Hosts) > 0 || len(c.HostURLs) > 0 {
+		cluster := c.ClusterConfig
+		if cluster.Name == "" {
+			cluster.Name = defaultClusterName
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	return append(clusters, c.Clusters...)
+}
+
+// ClusterSet searches the VMs of several independent Proxmox clusters and
+// routes updates to the cluster a VM belongs to.
+type ClusterSet struct {
+	pools []*ClientPool
+}
+
+func NewClient(config *Config) (*ClusterSet, error) {
+	clusterSet := &ClusterSet{}
+	for _, clusterConfig := range config.EffectiveClusters() {
+		pool, err := NewClientPool(&clusterConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client for Proxmox cluster %s: %w", clusterConfig.Name, err)
+		}
+
+		clusterSet.pools = append(clusterSet.pools, pool)
+	}
+
+	return clusterSet, nil
+}
+
+// Start keeps the VM inventory of every cluster refreshed until ctx is cancelled.
+func (s *ClusterSet) Start(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+	for _, pool := range s.pools {
+		g.Go(func() error {
+			return pool.Start(gctx)
+		})
+	}
+
+	return g.Wait()
+}
+
+// GetVMByUUID searches the clusters in configuration order and returns the
+// first VM with the given UUID. Errors of clusters searched before the match
+// are logged; when nothing is found they are returned joined.
+func (s *ClusterSet) GetVMByUUID(ctx context.Context, uuid string) (*VM, error) {
+	return s.search(func(pool *ClientPool) (*VM, error) {
+		return pool.GetVMByUUID(ctx, uuid)
+	})
+}
+
+// GetVMByMAC searches the clusters like GetVMByUUID and returns the first VM
+// owning any of macs.
+func (s *ClusterSet) GetVMByMAC(ctx context.Context, macs []string) (*VM, error) {
+	return s.search(func(pool *ClientPool) (*VM, error) {
+		return pool.GetVMByMAC(ctx, macs)
+	})
+}
+
+// GetVMByHostname searches the clusters like GetVMByUUID and returns the
+// first container with any of hostnames.
+func (s *ClusterSet) GetVMByHostname(ctx context.Context, hostnames []string) (*VM, error) {
+	return s.search(func(pool *ClientPool) (*VM, error) {
+		return pool.GetVMByHostname(ctx, hostnames)
+	})
+}
+
+// GetVMByGuestAgent searches the clusters like GetVMByUUID and returns the
+// first VM whose guest agent reports any of hostnames or ips.
+func (s *ClusterSet) GetVMByGuestAgent(ctx context.Context, hostnames []string, ips []string) (*VM, error) {
+	return s.search(func(pool *ClientPool) (*VM, error) {
+		return pool.GetVMByGuestAgent(ctx, hostnames, ips)
+	})
+}
+
+// GetVMByID reads the VM with vmid from the named cluster, or searches all
+// clusters like GetVMByUUID when cluster is empty.
+func (s *ClusterSet) GetVMByID(ctx context.Context, cluster string, vmid int) (*VM, error) {
+	if cluster == "" {
+		return s.search(func(pool *ClientPool) (*VM, error) {
+			return pool.GetVMByID(ctx, vmid)
+		})
+	}
+
+	pool, err := s.pool(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return pool.GetVMByID(ctx, vmid)
+}
+
+// GetVMsByName returns the VMs and containers named name in the named cluster.
+func (s *ClusterSet) GetVMsByName(ctx context.Context, cluster string, name string) ([]VM, error) {
+	pool, err := s.pool(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return pool.GetVMsByName(ctx, name)
+}
+
+func (s *ClusterSet) search(get func(pool *ClientPool) (*VM, error)) (*VM, error) {
+	var errs []error
+	for _, pool := range s.pools {
+		vm, err := get(pool)
+		if vm != nil {
+			for _, searchErr := range errs {
+				slog.Warn("Failed to search Proxmox cluster", "error", searchErr)
+			}
+			return vm, err
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", pool.name, err))
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// UpdateVMName renames the VM, or sets the hostname of the container, in the
+// cluster it was found in.
+func (s *ClusterSet) UpdateVMName(ctx context.Context, vm *VM, newName string) error {
+	pool, err := s.pool(vm.Cluster)
+	if err != nil {
+		return err
+	}
+
+	if vm.GuestType == GuestTypeLXC {
+		return pool.UpdateContainerName(ctx, vm.Node, vm.ID, newName)
+	}
+
+	return pool.UpdateVMName(ctx, vm.Node, vm.ID, newName)
+}
+
+// UpdateVMDescription replaces the description of the VM or container in the
+// cluster it was found in.
+func (s *ClusterSet) UpdateVMDescription(ctx context.Context, vm *VM, description string) error {
+	pool, err := s.pool(vm.Cluster)
+	if err != nil {
+		return err
+	}
+
+	if vm.GuestType == GuestTypeLXC {
+		return pool.UpdateContainerDescription(ctx, vm.Node, vm.ID, description)
+	}
+
+	return pool.UpdateVMDescription(ctx, vm.Node, vm.ID, description)
+}
+
+func (s *ClusterSet) pool(name string) (*ClientPool, error) {
+	for _, pool := range s.pools {
+		if pool.name == name {
+			return pool, nil
+		}
+	}
+
+	return nil, &Error{Kind: ErrorNotFound, Err: fmt.Errorf("unknown Proxmox cluster %q", name)}
+}
@@ -0,0 +1,155 @@
+package proxmox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterSet_SearchesAllClusters(t *testing.T) {
+	east := newFakeProxmox(t)
+	east.addVM("pve-east", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(1)})
+	west := newFakeProxmox(t)
+	west.addVM("pve-west", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(2)})
+
+	clusterSet, err := NewClient(&Config{Clusters: []ClusterConfig{
+		{Name: "east", HostURLs: []string{east.URL}, TokenID: "test@pve!test", Secret: "secret"},
+		{Name: "west", HostURLs: []string{west.URL}, TokenID: "test@pve!test", Secret: "secret"},
+	}})
+	require.NoError(t, err)
+
+	vm, err := clusterSet.GetVMByUUID(t.Context(), testUUID(2))
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, "west", vm.Cluster)
+
+	require.NoError(t, clusterSet.UpdateVMName(t.Context(), vm, "worker-01"))
+	assert.Equal(t, "worker-01", west.nodes["pve-west"][0].Name)
+	assert.Equal(t, "vm-100", east.nodes["pve-east"][0].Name, "VM with the same id in another cluster is untouched")
+
+	vm, err = clusterSet.GetVMByUUID(t.Context(), testUUID(3))
+	require.NoError(t, err)
+	assert.Nil(t, vm)
+}
+
+func TestClusterSet_GetVMByID(t *testing.T) {
+	east := newFakeProxmox(t)
+	east.addVM("pve-east", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(1), Net0: "virtio=BC:24:11:00:01:00,bridge=vmbr0"})
+	west := newFakeProxmox(t)
+	west.addVM("pve-west", fakeVM{ID: 200, Name: "vm-200", SMBIOS: "uuid=" + testUUID(2)})
+
+	clusterSet, err := NewClient(&Config{Clusters: []ClusterConfig{
+		{Name: "east", HostURLs: []string{east.URL}, TokenID: "test@pve!test", Secret: "secret"},
+		{Name: "west", HostURLs: []string{west.URL}, TokenID: "test@pve!test", Secret: "secret"},
+	}})
+	require.NoError(t, err)
+
+	vm, err := clusterSet.GetVMByID(t.Context(), "east", 100)
+	require.NoError(t, err)
+	assert.Equal(t, &VM{ID: 100, Name: "vm-100", Node: "pve-east", GuestType: GuestTypeQEMU, UUID: testUUID(1), Cluster: "east", Status: "running", MACs: []string{"bc:24:11:00:01:00"}}, vm)
+	assert.Equal(t, int64(0), east.requestCount("/nodes/pve-east/qemu"), "VMs are not listed")
+
+	resources := east.requestCount("/cluster/resources")
+	vm, err = clusterSet.GetVMByID(t.Context(), "east", 100)
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, resources, east.requestCount("/cluster/resources"), "known VMs are served from the inventory")
+	assert.Equal(t, int64(1), east.requestCount("/nodes/pve-east/qemu/100/config"))
+
+	vm, err = clusterSet.GetVMByID(t.Context(), "east", 200)
+	require.NoError(t, err)
+	assert.Nil(t, vm, "VM ids are not looked up in other clusters")
+
+	vm, err = clusterSet.GetVMByID(t.Context(), "", 200)
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, "west", vm.Cluster)
+
+	_, err = clusterSet.GetVMByID(t.Context(), "north", 100)
+	assert.Equal(t, ErrorNotFound, KindOf(err))
+}
+
+func TestClusterSet_GetVMsByName(t *testing.T) {
+	east := newFakeProxmox(t)
+	east.addVM("pve-1", fakeVM{ID: 100, Name: "worker-01", SMBIOS: "uuid=" + testUUID(100)})
+	east.addVM("pve-2", fakeVM{ID: 101, Name: "worker-01", SMBIOS: "uuid=" + testUUID(101)})
+	east.addVM("pve-2", fakeVM{ID: 102, Name: "worker-02", SMBIOS: "uuid=" + testUUID(102)})
+	west := newFakeProxmox(t)
+	west.addVM("pve-west", fakeVM{ID: 200, Name: "worker-01", SMBIOS: "uuid=" + testUUID(200)})
+
+	clusterSet, err := NewClient(&Config{Clusters: []ClusterConfig{
+		{Name: "east", HostURLs: []string{east.URL}, TokenID: "test@pve!test", Secret: "secret"},
+		{Name: "west", HostURLs: []string{west.URL}, TokenID: "test@pve!test", Secret: "secret"},
+	}})
+	require.NoError(t, err)
+
+	vms, err := clusterSet.GetVMsByName(t.Context(), "east", "worker-01")
+	require.NoError(t, err)
+	ids := make([]int, 0, len(vms))
+	for _, vm := range vms {
+		ids = append(ids, vm.ID)
+	}
+	assert.Equal(t, []int{100, 101}, ids, "VMs of other clusters are not included")
+
+	vms, err = clusterSet.GetVMsByName(t.Context(), "east", "worker-03")
+	require.NoError(t, err)
+	assert.Empty(t, vms)
+}
+
+func TestClusterSet_GetVMsByName_AfterRename(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+	f.addVM("pve-1", fakeVM{ID: 101, Name: "vm-101", SMBIOS: "uuid=" + testUUID(101)})
+	f.addVM("pve-1", fakeVM{ID: 102, Type: GuestTypeLXC, Name: "ct-102", Hostname: "ct-102"})
+	clusterSet, err := NewClient(&Config{Clusters: []ClusterConfig{
+		{Name: "test", HostURLs: []string{f.URL}, TokenID: "test@pve!test", Secret: "secret"},
+	}})
+	require.NoError(t, err)
+
+	// Rename every guest to "foo" unless the name is already taken, as the
+	// controller does for nodes reconciled back to back.
+	var renamed []int
+	for _, id := range []int{100, 101, 102} {
+		vm, err := clusterSet.GetVMByID(t.Context(), "test", id)
+		require.NoError(t, err)
+		require.NotNil(t, vm)
+
+		taken, err := clusterSet.GetVMsByName(t.Context(), "test", "foo")
+		require.NoError(t, err)
+		if len(taken) > 0 {
+			continue
+		}
+		require.NoError(t, clusterSet.UpdateVMName(t.Context(), vm, "foo"))
+		renamed = append(renamed, id)
+	}
+
+	assert.Equal(t, []int{100}, renamed, "a just renamed VM holds its new name")
+}
+
+func TestClusterSet_UpdateVMDescription(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100), Description: "web tier"})
+	f.addVM("pve-1", fakeVM{ID: 101, Type: GuestTypeLXC, Name: "edge-01", Hostname: "edge-01"})
+	clusterSet, err := NewClient(&Config{ClusterConfig: ClusterConfig{
+		Name: "test", HostURLs: []string{f.URL}, TokenID: "test@pve!test", Secret: "secret",
+	}})
+	require.NoError(t, err)
+
+	vm, err := clusterSet.GetVMByUUID(t.Context(), testUUID(100))
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, "web tier", vm.Description)
+	require.NoError(t, clusterSet.UpdateVMDescription(t.Context(), vm, "web tier\nmarker"))
+	assert.Equal(t, "web tier\nmarker", f.nodes["pve-1"][0].Description)
+
+	container, err := clusterSet.GetVMByHostname(t.Context(), []string{"edge-01"})
+	require.NoError(t, err)
+	require.NotNil(t, container)
+	require.NoError(t, clusterSet.UpdateVMDescription(t.Context(), container, "marker"))
+	assert.Equal(t, "marker", f.nodes["pve-1"][1].Description)
+
+	vm, err = clusterSet.GetVMByID(t.Context(), "test", 100)
+	require.NoError(t, err)
+	assert.Equal(t, "web tier\nmarker", vm.Description)
+}
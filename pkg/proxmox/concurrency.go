@@ -0,0 +1,44 @@
+package proxmox
+
+import "context"
+
+const (
+	defaultGlobalConcurrency  = 16
+	defaultPerNodeConcurrency = 4
+)
+
+type ConcurrencyConfig struct {
+	// Global limits the number of concurrent Proxmox API calls made while listing VMs.
+	Global int `json:"global"`
+	// PerNode limits the number of VMs of a single PVE node fetched concurrently.
+	PerNode int `json:"perNode"`
+}
+
+func (c ConcurrencyConfig) withDefaults() ConcurrencyConfig {
+	if c.Global <= 0 {
+		c.Global = defaultGlobalConcurrency
+	}
+	if c.PerNode <= 0 {
+		c.PerNode = defaultPerNodeConcurrency
+	}
+
+	return c
+}
+
+type semaphore chan struct{}
+
+func newSemaphore(size int) semaphore {
+	return make(semaphore, size)
+}
+
+// do runs fn once a slot is free, or returns the context error if ctx is done first.
+func (s semaphore) do(ctx context.Context, fn func() error) error {
+	select {
+	case s <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s }()
+
+	return fn()
+}
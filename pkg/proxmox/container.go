@@ -0,0 +1,147 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/luthermonson/go-proxmox"
+	"golang.org/x/sync/errgroup"
+)
+
+// newContainerVM builds the VM of an LXC container from its config.
+// Containers have no SMBIOS; they are matched by hostname or MAC address.
+func newContainerVM(cluster, nodeName string, vmid int, name string, config *proxmox.ContainerConfig) VM {
+	return VM{
+		ID:          vmid,
+		Name:        name,
+		Node:        nodeName,
+		GuestType:   GuestTypeLXC,
+		Hostname:    strings.ToLower(config.Hostname),
+		Cluster:     cluster,
+		MACs:        macAddresses(config.MergeNets()),
+		Template:    bool(config.Template),
+		Lock:        config.Lock,
+		Description: config.Description,
+	}
+}
+
+func fetchContainerConfig(ctx context.Context, client *proxmox.Client, nodeName string, vmid int) (*proxmox.ContainerConfig, error) {
+	var config proxmox.ContainerConfig
+	if err := client.Get(ctx, fmt.Sprintf("/nodes/%s/lxc/%d/config", nodeName, vmid), &config); err != nil {
+		return nil, fmt.Errorf("failed to get config of container %d on node %s: %w", vmid, nodeName, err)
+	}
+
+	return &config, nil
+}
+
+func (c *ClientPool) getNodeContainers(ctx context.Context, client *proxmox.Client, nodeName string, global semaphore) ([]VM, error) {
+	var containers proxmox.Containers
+	err := c.retry.do(ctx, "list node containers", func() error {
+		return global.do(ctx, func() error {
+			if err := client.Get(ctx, fmt.Sprintf("/nodes/%s/lxc", nodeName), &containers); err != nil {
+				return fmt.Errorf("failed to get containers from node %s: %w", nodeName, err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*VM, len(containers))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency.PerNode)
+	for i, container := range containers {
+		g.Go(func() error {
+			vmid := int(container.VMID)
+			var config *proxmox.ContainerConfig
+			err := c.retry.do(gctx, "get container config", func() error {
+				return global.do(gctx, func() error {
+					var err error
+					config, err = fetchContainerConfig(gctx, client, nodeName, vmid)
+					return err
+				})
+			})
+			if err != nil {
+				return err
+			}
+
+			result := newContainerVM(c.name, nodeName, vmid, container.Name, config)
+			result.Status = container.Status
+			if !result.matchable() {
+				slog.Info("Skipping container with no hostname and no MAC address", "vmid", vmid, "node", nodeName)
+				return nil
+			}
+			results[i] = &result
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	nodeContainers := make([]VM, 0, len(results))
+	for _, vm := range results {
+		if vm != nil {
+			nodeContainers = append(nodeContainers, *vm)
+		}
+	}
+
+	return nodeContainers, nil
+}
+
+// UpdateContainerName sets the hostname of the container, which Proxmox also
+// shows as its name and the guest uses from its next start. Container config
+// changes apply immediately without a task. Failures are returned as *Error.
+func (c *ClientPool) UpdateContainerName(ctx context.Context, nodeName string, vmid int, newName string) error {
+	return classify(c.retry.do(ctx, "update container hostname", func() error {
+		return c.updateContainerConfig(ctx, nodeName, vmid, "hostname", newName)
+	}))
+}
+
+// UpdateContainerDescription replaces the description of the container like
+// UpdateContainerName.
+func (c *ClientPool) UpdateContainerDescription(ctx context.Context, nodeName string, vmid int, description string) error {
+	return classify(c.retry.do(ctx, "update container description", func() error {
+		return c.updateContainerConfig(ctx, nodeName, vmid, "description", description)
+	}))
+}
+
+func (c *ClientPool) updateContainerConfig(ctx context.Context, nodeName string, vmid int, key, value string) error {
+	client, err := c.getClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get client: %w", err)
+	}
+
+	path := fmt.Sprintf("/nodes/%s/lxc/%d/config", nodeName, vmid)
+	if err := client.Put(ctx, path, map[string]string{key: value}, nil); err != nil {
+		return fmt.Errorf("failed to update container %d %s: %w", vmid, key, err)
+	}
+
+	c.discovery.evict(vmid)
+	c.inventory.invalidate(nodeName, vmid, func(vm *VM) { vm.applyConfig(key, value) })
+	return nil
+}
+
+// GetVMByHostname returns the container whose hostname equals any of
+// hostnames, trying them in order. A fully qualified hostname also matches a
+// container configured with just its first label.
+func (c *ClientPool) GetVMByHostname(ctx context.Context, hostnames []string) (*VM, error) {
+	var keys []string
+	for _, hostname := range hostnames {
+		hostname = strings.ToLower(strings.TrimSpace(hostname))
+		if hostname == "" {
+			continue
+		}
+		keys = append(keys, hostnameKey(hostname))
+		if short, _, found := strings.Cut(hostname, "."); found {
+			keys = append(keys, hostnameKey(short))
+		}
+	}
+
+	return c.getByAnyKey(ctx, keys)
+}
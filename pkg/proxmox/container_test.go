@@ -0,0 +1,69 @@
+package proxmox
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientPool_Containers(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+	f.addVM("pve-1", fakeVM{ID: 101, Type: GuestTypeLXC, Name: "edge-01", Hostname: "Edge-01",
+		Net0: "name=eth0,bridge=vmbr0,hwaddr=BC:24:11:00:01:01,ip=dhcp,type=veth"})
+	pool := newTestClientPool(t, f)
+
+	container := VM{ID: 101, Name: "edge-01", Node: "pve-1", GuestType: GuestTypeLXC, Hostname: "edge-01",
+		Cluster: "test", MACs: []string{"bc:24:11:00:01:01"}, Status: "running"}
+
+	vms, err := pool.listVMs(t.Context())
+	require.NoError(t, err)
+	assert.Contains(t, vms, container)
+
+	vms, err = pool.GetVMs(t.Context())
+	require.NoError(t, err)
+	assert.Contains(t, vms, container, "per-node listing includes containers")
+
+	for _, hostnames := range [][]string{{"edge-01"}, {"worker-09", "EDGE-01.example.com"}} {
+		vm, err := pool.GetVMByHostname(t.Context(), hostnames)
+		require.NoError(t, err)
+		assert.Equal(t, &container, vm, hostnames)
+	}
+
+	vm, err := pool.GetVMByMAC(t.Context(), []string{"bc:24:11:00:01:01"})
+	require.NoError(t, err)
+	assert.Equal(t, &container, vm)
+
+	vm, err = pool.GetVMByID(t.Context(), 101)
+	require.NoError(t, err)
+	assert.Equal(t, &container, vm)
+
+	vm, err = pool.GetVMByHostname(t.Context(), []string{"vm-100"})
+	require.NoError(t, err)
+	assert.Nil(t, vm, "QEMU VMs are not matched by name")
+}
+
+func TestClusterSet_UpdateVMName_Container(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 101, Type: GuestTypeLXC, Name: "edge-01", Hostname: "edge-01"})
+	clusterSet, err := NewClient(&Config{ClusterConfig: ClusterConfig{
+		Name: "test", HostURLs: []string{f.URL}, TokenID: "test@pve!test", Secret: "secret",
+	}})
+	require.NoError(t, err)
+
+	vm, err := clusterSet.GetVMByHostname(t.Context(), []string{"edge-01"})
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+
+	require.NoError(t, clusterSet.UpdateVMName(t.Context(), vm, "worker-01"))
+	assert.Equal(t, "worker-01", f.nodes["pve-1"][0].Hostname)
+	assert.Equal(t, int64(1), f.methodCount(http.MethodPut, "/nodes/pve-1/lxc/101/config"))
+	assert.Equal(t, int64(0), f.methodCount(http.MethodPost, "/nodes/pve-1/qemu/101/config"))
+
+	vm, err = clusterSet.GetVMByHostname(t.Context(), []string{"worker-01"})
+	require.NoError(t, err)
+	require.NotNil(t, vm, "renamed container is re-read")
+	assert.Equal(t, "worker-01", vm.Name)
+}
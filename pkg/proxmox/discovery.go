@@ -0,0 +1,273 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultConfigCacheTTL = time.Hour
+
+	// resourceStatusUnknown is reported for guests on nodes the cluster cannot reach.
+	resourceStatusUnknown = "unknown"
+	// guestStatusRunning is the status of a started VM or container.
+	guestStatusRunning = "running"
+)
+
+// resourceDiscovery lists VMs and containers with a single /cluster/resources
+// call and only reads a guest's config when it is new, has moved or changed
+// name, or its cached config is older than the TTL. The run state and config
+// lock are taken from /cluster/resources on every discovery, but it does not
+// expose the config digest, so the TTL bounds how long an SMBIOS change can go
+// unnoticed.
+type resourceDiscovery struct {
+	cluster string
+	ttl     time.Duration
+	retry   RetryConfig
+
+	// mu guards known and evicted. It is not held while configs are read, so
+	// that evict does not wait for a whole discovery.
+	mu    sync.Mutex
+	known map[int]discoveredVM
+	// evicted records the guests evicted since the running discovery took
+	// its snapshot of known; their configs may be outdated and are not cached.
+	evicted map[int]bool
+}
+
+type discoveredVM struct {
+	vm        VM
+	digest    string
+	fetchedAt time.Time
+}
+
+// clusterResource is an entry of /cluster/resources. go-proxmox does not
+// decode the config lock of guests.
+type clusterResource struct {
+	proxmox.ClusterResource
+	Lock string `json:"lock,omitempty"`
+}
+
+func newResourceDiscovery(cluster string, cfg InventoryConfig, retry RetryConfig) *resourceDiscovery {
+	return &resourceDiscovery{
+		cluster: cluster,
+		retry:   retry,
+		ttl:     durationOrDefault(cfg.ConfigCacheTTL, defaultConfigCacheTTL),
+		known:   make(map[int]discoveredVM),
+		evicted: make(map[int]bool),
+	}
+}
+
+// getClusterGuests lists the VMs and containers of the cluster.
+func getClusterGuests(ctx context.Context, client *proxmox.Client) ([]*clusterResource, error) {
+	var resources []*clusterResource
+	if err := client.Get(ctx, "/cluster/resources?type=vm", &resources); err != nil {
+		return nil, fmt.Errorf("failed to get cluster resources: %w", err)
+	}
+
+	return slices.DeleteFunc(resources, func(resource *clusterResource) bool {
+		return resource.Type != string(GuestTypeQEMU) && resource.Type != string(GuestTypeLXC)
+	}), nil
+}
+
+// discover lists the guests of the cluster. Configs that have to be read are
+// fetched concurrently, bounded by the global and per-node limits of
+// concurrency, so that a slow PVE node does not hold up the others.
+func (d *resourceDiscovery) discover(ctx context.Context, client *proxmox.Client, concurrency ConcurrencyConfig) ([]VM, error) {
+	var resources []*clusterResource
+	err := d.retry.do(ctx, "get cluster resources", func() error {
+		var err error
+		resources, err = getClusterGuests(ctx, client)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var guests []*clusterResource
+	for _, resource := range resources {
+		if resource.Status == resourceStatusUnknown {
+			slog.Info("Skipping guest on unreachable node", "vmid", resource.VMID, "node", resource.Node)
+			continue
+		}
+		guests = append(guests, resource)
+	}
+
+	d.mu.Lock()
+	cached := make([]*discoveredVM, len(guests))
+	for i, resource := range guests {
+		if entry, ok := d.known[int(resource.VMID)]; ok && !d.changed(entry, resource) {
+			cached[i] = &entry
+		}
+	}
+	d.evicted = make(map[int]bool)
+	d.mu.Unlock()
+
+	fetched, errs := d.fetchChanged(ctx, client, guests, cached, concurrency)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	known := make(map[int]discoveredVM, len(guests))
+	failed := make(map[string]bool)
+	var allVMs []VM
+	var nodeErrs []NodeError
+	for i, resource := range guests {
+		if failed[resource.Node] {
+			continue
+		}
+		if errs[i] != nil {
+			slog.Warn("Failed to list VMs of node", "node", resource.Node, "error", errs[i])
+			failed[resource.Node] = true
+			nodeErrs = append(nodeErrs, NodeError{Node: resource.Node, Err: classify(errs[i])})
+			continue
+		}
+
+		vmid := int(resource.VMID)
+		entry := cached[i]
+		if entry == nil {
+			entry = fetched[i]
+			if !entry.vm.matchable() {
+				slog.Info("Skipping guest with no uuid, hostname or MAC address", "vmid", vmid, "node", resource.Node)
+			}
+			if previous, ok := d.known[vmid]; ok && previous.digest != entry.digest {
+				slog.Debug("Guest config changed", "vmid", vmid, "node", resource.Node)
+			}
+		}
+
+		// The run state and lock change without a config change, so they
+		// are always taken from the resource.
+		entry.vm.Status = resource.Status
+		entry.vm.Lock = resource.Lock
+		if !d.evicted[vmid] {
+			known[vmid] = *entry
+		}
+		if entry.vm.matchable() {
+			allVMs = append(allVMs, entry.vm)
+		}
+	}
+
+	d.known = known
+
+	if len(failed) > 0 {
+		allVMs = slices.DeleteFunc(allVMs, func(vm VM) bool { return failed[vm.Node] })
+	}
+
+	return allVMs, partialResult(nodeErrs)
+}
+
+// fetchChanged reads the configs of the guests that have no cached entry.
+// The result is nil for guests served from the cache. Once a config of a node
+// fails, the node's remaining configs are not read.
+func (d *resourceDiscovery) fetchChanged(ctx context.Context, client *proxmox.Client, guests []*clusterResource,
+	cached []*discoveredVM, concurrency ConcurrencyConfig) ([]*discoveredVM, []error) {
+	fetched := make([]*discoveredVM, len(guests))
+	errs := make([]error, len(guests))
+
+	global := newSemaphore(concurrency.Global)
+	perNode := make(map[string]semaphore)
+	var failedMu sync.Mutex
+	failed := make(map[string]error)
+
+	var g errgroup.Group
+	for i, resource := range guests {
+		if cached[i] != nil {
+			continue
+		}
+		node, ok := perNode[resource.Node]
+		if !ok {
+			node = newSemaphore(concurrency.PerNode)
+			perNode[resource.Node] = node
+		}
+
+		g.Go(func() error {
+			errs[i] = node.do(ctx, func() error {
+				failedMu.Lock()
+				err := failed[resource.Node]
+				failedMu.Unlock()
+				if err != nil {
+					return err
+				}
+
+				var vm VM
+				var digest string
+				err = d.retry.do(ctx, "get guest config", func() error {
+					return global.do(ctx, func() error {
+						var err error
+						vm, digest, err = fetchGuest(ctx, client, d.cluster, resource)
+						return err
+					})
+				})
+				if err != nil {
+					failedMu.Lock()
+					failed[resource.Node] = err
+					failedMu.Unlock()
+					return err
+				}
+
+				fetched[i] = &discoveredVM{vm: vm, digest: digest, fetchedAt: time.Now()}
+				return nil
+			})
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	return fetched, errs
+}
+
+// evict drops the cached config of vmid, so that the next discovery reads it
+// again. It is called after the controller changed the config.
+func (d *resourceDiscovery) evict(vmid int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.known, vmid)
+	d.evicted[vmid] = true
+}
+
+func (d *resourceDiscovery) changed(cached discoveredVM, resource *clusterResource) bool {
+	return cached.vm.Node != resource.Node ||
+		cached.vm.Name != resource.Name ||
+		time.Since(cached.fetchedAt) > d.ttl
+}
+
+// fetchGuest reads the config of the VM or container behind resource and
+// returns it together with the config digest.
+func fetchGuest(ctx context.Context, client *proxmox.Client, cluster string, resource *clusterResource) (VM, string, error) {
+	vmid := int(resource.VMID)
+	var vm VM
+	var digest string
+	if resource.Type == string(GuestTypeLXC) {
+		config, err := fetchContainerConfig(ctx, client, resource.Node, vmid)
+		if err != nil {
+			return VM{}, "", err
+		}
+		vm, digest = newContainerVM(cluster, resource.Node, vmid, resource.Name, config), config.Digest
+	} else {
+		config, err := fetchVMConfig(ctx, client, resource.Node, vmid)
+		if err != nil {
+			return VM{}, "", err
+		}
+		vm, digest = newQEMUVM(cluster, resource.Node, vmid, resource.Name, config), config.Digest
+	}
+	vm.Status = resource.Status
+	vm.Lock = resource.Lock
+
+	return vm, digest, nil
+}
+
+func fetchVMConfig(ctx context.Context, client *proxmox.Client, nodeName string, vmid int) (*proxmox.VirtualMachineConfig, error) {
+	var config proxmox.VirtualMachineConfig
+	if err := client.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", nodeName, vmid), &config); err != nil {
+		return nil, fmt.Errorf("failed to get config of VM %d on node %s: %w", vmid, nodeName, err)
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,160 @@
+package proxmox
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceDiscovery_OnlyFetchesChangedConfigs(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+	f.addVM("pve-1", fakeVM{ID: 101, Name: "vm-101", SMBIOS: "uuid=" + testUUID(101)})
+	f.addVM("pve-2", fakeVM{ID: 200, Name: "vm-200", SMBIOS: "uuid=" + testUUID(200)})
+	pool := newTestClientPool(t, f)
+
+	vms, err := pool.listVMs(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []VM{
+		{ID: 100, Name: "vm-100", Node: "pve-1", GuestType: GuestTypeQEMU, UUID: testUUID(100), Cluster: "test", Status: "running"},
+		{ID: 101, Name: "vm-101", Node: "pve-1", GuestType: GuestTypeQEMU, UUID: testUUID(101), Cluster: "test", Status: "running"},
+		{ID: 200, Name: "vm-200", Node: "pve-2", GuestType: GuestTypeQEMU, UUID: testUUID(200), Cluster: "test", Status: "running"},
+	}, vms)
+	assert.Equal(t, int64(1), f.requestCount("/nodes/pve-1/qemu/100/config"))
+
+	require.NoError(t, pool.UpdateVMName(t.Context(), "pve-1", 100, "renamed"))
+
+	vms, err = pool.listVMs(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", vms[0].Name)
+	// One read from UpdateVMName and one from the rediscovery of the renamed VM.
+	assert.Equal(t, int64(3), f.requestCount("/nodes/pve-1/qemu/100/config"), "renamed VM is re-read")
+	assert.Equal(t, int64(1), f.requestCount("/nodes/pve-1/qemu/101/config"), "unchanged VM is served from cache")
+	assert.Equal(t, int64(0), f.requestCount("/nodes/pve-1/qemu"), "per-node listing is not used")
+}
+
+func TestResourceDiscovery_RereadsWrittenConfigs(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+	pool := newTestClientPool(t, f)
+
+	_, err := pool.listVMs(t.Context())
+	require.NoError(t, err)
+	require.NoError(t, pool.UpdateVMDescription(t.Context(), "pve-1", 100, "marker"))
+
+	for range 3 {
+		vm, err := pool.GetVMByUUID(t.Context(), testUUID(100))
+		require.NoError(t, err)
+		require.NotNil(t, vm)
+		assert.Equal(t, "marker", vm.Description, "the written config is not served from the cache")
+	}
+	assert.Equal(t, int64(2), f.requestCount("/cluster/resources"), "a single refresh follows the write")
+}
+
+func TestResourceDiscovery_LockFromResources(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100), Lock: "backup"})
+	pool := newTestClientPool(t, f)
+
+	vms, err := pool.listVMs(t.Context())
+	require.NoError(t, err)
+	require.Len(t, vms, 1)
+	assert.Equal(t, "backup", vms[0].Lock)
+
+	f.mu.Lock()
+	f.nodes["pve-1"][0].Lock = ""
+	f.mu.Unlock()
+
+	vms, err = pool.listVMs(t.Context())
+	require.NoError(t, err)
+	require.Len(t, vms, 1)
+	assert.Empty(t, vms[0].Lock, "the lock is not served from the config cache")
+	assert.Equal(t, int64(1), f.requestCount("/nodes/pve-1/qemu/100/config"))
+}
+
+func TestResourceDiscovery_EvictDuringDiscovery(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+	f.addVM("pve-1", fakeVM{ID: 101, Name: "vm-101", SMBIOS: "uuid=" + testUUID(101)})
+	pool := newTestClientPool(t, f)
+
+	_, err := pool.listVMs(t.Context())
+	require.NoError(t, err)
+
+	f.latency = 50 * time.Millisecond
+	pool.discovery.evict(101)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := pool.listVMs(t.Context())
+		assert.NoError(t, err)
+	}()
+
+	time.Sleep(75 * time.Millisecond)
+	start := time.Now()
+	pool.discovery.evict(100)
+	assert.Less(t, time.Since(start), 25*time.Millisecond, "evict does not wait for config reads")
+	<-done
+
+	_, ok := pool.discovery.known[100]
+	assert.False(t, ok, "a guest evicted during discovery is not cached")
+}
+
+func TestResourceDiscovery_Flags(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100), Lock: "backup"})
+	f.addVM("pve-1", fakeVM{ID: 9000, Name: "template", SMBIOS: "uuid=" + testUUID(9000), Template: true})
+	f.addVM("pve-1", fakeVM{ID: 101, Type: GuestTypeLXC, Name: "ct-101", Hostname: "ct-101", Template: true})
+	pool := newTestClientPool(t, f)
+
+	vms, err := pool.listVMs(t.Context())
+	require.NoError(t, err)
+	require.Len(t, vms, 3)
+	assert.Equal(t, "backup", vms[0].Lock)
+	assert.Equal(t, "running", vms[0].Status)
+	assert.False(t, vms[0].Template)
+	assert.True(t, vms[1].Template)
+	assert.True(t, vms[2].Template)
+
+	vm, err := pool.GetVMByUUID(t.Context(), testUUID(9000))
+	require.NoError(t, err)
+	assert.Nil(t, vm, "templates are not matched by default")
+
+	vm, err = pool.GetVMByID(t.Context(), 9000)
+	require.NoError(t, err)
+	assert.Nil(t, vm)
+}
+
+func TestResourceDiscovery_ConcurrentConfigReads(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.latency = 5 * time.Millisecond
+	var expected []int
+	for n := range 3 {
+		for i := range 6 {
+			id := 100*(n+1) + i
+			f.addVM(fmt.Sprintf("pve-%d", n), fakeVM{ID: id, Name: fmt.Sprintf("vm-%d", id), SMBIOS: "uuid=" + testUUID(id)})
+			if n != 2 {
+				expected = append(expected, id)
+			}
+		}
+	}
+	f.failing["pve-2"] = true
+	pool := newTestClientPool(t, f)
+	pool.concurrency = ConcurrencyConfig{Global: 4, PerNode: 2}
+
+	vms, err := pool.listVMs(t.Context())
+	var partial *PartialResultError
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, []string{"pve-2"}, partial.FailedNodes())
+
+	ids := make([]int, 0, len(vms))
+	for _, vm := range vms {
+		ids = append(ids, vm.ID)
+	}
+	assert.Equal(t, expected, ids, "VMs are returned in resource order")
+	assert.Greater(t, f.maxInFlight.Load(), int64(1), "configs are read concurrently")
+	assert.LessOrEqual(t, f.maxInFlight.Load(), int64(4), "the global limit holds")
+}
@@ -0,0 +1,40 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that is read from and written to config files
+// in its string form, e.g. "30s" or "5m".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+
+	d.Duration = parsed
+	return nil
+}
+
+func durationOrDefault(d Duration, def time.Duration) time.Duration {
+	if d.Duration <= 0 {
+		return def
+	}
+
+	return d.Duration
+}
@@ -0,0 +1,191 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// ErrorKind categorizes a failed Proxmox call by what the caller can do about it.
+type ErrorKind string
+
+const (
+	// ErrorUnknown is any failure that does not fit one of the other kinds.
+	ErrorUnknown ErrorKind = "unknown"
+	// ErrorUnreachable means no Proxmox host or PVE node could be reached.
+	ErrorUnreachable ErrorKind = "unreachable"
+	// ErrorUnauthorized means Proxmox rejected the configured credentials.
+	ErrorUnauthorized ErrorKind = "unauthorized"
+	// ErrorForbidden means the user or token lacks a required privilege.
+	ErrorForbidden ErrorKind = "forbidden"
+	// ErrorNotFound means the VM or node does not exist (anymore).
+	ErrorNotFound ErrorKind = "not found"
+	// ErrorVMLocked means the VM is locked, e.g. by a running backup or migration.
+	ErrorVMLocked ErrorKind = "VM locked"
+	// ErrorTaskFailed means Proxmox accepted the request but its task failed.
+	ErrorTaskFailed ErrorKind = "task failed"
+	// ErrorTimeout means the call or the task did not finish in time.
+	ErrorTimeout ErrorKind = "timeout"
+	// ErrorDuplicateUUID means more than one VM has the UUID that was looked
+	// up, so the node's VM cannot be told apart from the others.
+	ErrorDuplicateUUID ErrorKind = "duplicate UUID"
+	// ErrorAmbiguousMatch means several VMs match the node equally well, so
+	// none of them is picked.
+	ErrorAmbiguousMatch ErrorKind = "ambiguous match"
+)
+
+// Error is a Proxmox failure together with its kind.
+type Error struct {
+	Kind ErrorKind
+	// StatusCode is the HTTP status of the Proxmox response, if there was one.
+	StatusCode int
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// DuplicateUUIDError lists the VMs sharing a UUID, typically clones of a VM or
+// template whose SMBIOS UUID was not regenerated.
+type DuplicateUUIDError struct {
+	UUID string
+	VMs  []VM
+}
+
+func (e *DuplicateUUIDError) Error() string {
+	ids := make([]string, 0, len(e.VMs))
+	for _, vm := range e.VMs {
+		ids = append(ids, fmt.Sprintf("%d on %s", vm.ID, vm.Node))
+	}
+
+	return fmt.Sprintf("UUID %s is shared by VMs %s", e.UUID, strings.Join(ids, ", "))
+}
+
+func newDuplicateUUIDError(uuid string, vms []VM) error {
+	return &Error{Kind: ErrorDuplicateUUID, Err: &DuplicateUUIDError{UUID: uuid, VMs: vms}}
+}
+
+// KindOf returns the kind of the first *Error in err's tree.
+func KindOf(err error) ErrorKind {
+	var proxmoxErr *Error
+	if errors.As(err, &proxmoxErr) {
+		return proxmoxErr.Kind
+	}
+
+	var invalid *InvalidCredentialsError
+	if errors.As(err, &invalid) {
+		return ErrorUnauthorized
+	}
+
+	return ErrorUnknown
+}
+
+// classify wraps err in an *Error unless it already carries one. HTTP status
+// codes are mapped by statusTransport; this handles transport and client
+// level failures.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var proxmoxErr *Error
+	var partial *PartialResultError
+	if errors.As(err, &proxmoxErr) || errors.As(err, &partial) {
+		return err
+	}
+
+	return &Error{Kind: kindOfCause(err), Err: err}
+}
+
+func kindOfCause(err error) ErrorKind {
+	// Every error returned by a transport is wrapped in a *url.Error, which
+	// itself satisfies net.Error; only its cause tells what went wrong.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+
+	var invalid *InvalidCredentialsError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &invalid), errors.Is(err, proxmox.ErrNotAuthorized):
+		return ErrorUnauthorized
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, proxmox.ErrTimeout):
+		return ErrorTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &netErr), errors.Is(err, errNoAvailableHost):
+		return ErrorUnreachable
+	default:
+		return ErrorUnknown
+	}
+}
+
+// statusTransport turns error responses of the Proxmox API into *Error values
+// before go-proxmox reduces them to their status line. Proxmox reports most
+// failures as 500 with the reason in the status text or body.
+type statusTransport struct {
+	next http.RoundTripper
+}
+
+// proxmoxStatusProxyError is returned by pveproxy when it cannot reach the
+// PVE node a request is proxied to.
+const proxmoxStatusProxyError = 595
+
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode < http.StatusBadRequest {
+		return res, err
+	}
+
+	var kind ErrorKind
+	message := res.Status
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		kind = ErrorUnauthorized
+	case http.StatusForbidden:
+		kind = ErrorForbidden
+	case http.StatusNotFound:
+		kind = ErrorNotFound
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, proxmoxStatusProxyError:
+		kind = ErrorUnreachable
+	case http.StatusInternalServerError:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		if text := strings.TrimSpace(string(body)); text != "" {
+			message += ": " + text
+		}
+		kind = kindOfMessage(message)
+	}
+	if kind == "" {
+		return res, nil
+	}
+
+	_ = res.Body.Close()
+	return nil, &Error{Kind: kind, StatusCode: res.StatusCode, Err: fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, message)}
+}
+
+func kindOfMessage(message string) ErrorKind {
+	message = strings.ToLower(message)
+	switch {
+	case strings.Contains(message, "does not exist"), strings.Contains(message, "no such"):
+		return ErrorNotFound
+	case strings.Contains(message, "is locked"), strings.Contains(message, "can't lock file"):
+		return ErrorVMLocked
+	case strings.Contains(message, "no route to host"), strings.Contains(message, "connection refused"):
+		return ErrorUnreachable
+	default:
+		return ErrorUnknown
+	}
+}
@@ -0,0 +1,72 @@
+package proxmox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusTransport(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   ErrorKind
+	}{
+		{name: "unauthorized", status: http.StatusUnauthorized, want: ErrorUnauthorized},
+		{name: "forbidden", status: http.StatusForbidden, body: "Permission check failed (/vms/100, VM.Config.Options)", want: ErrorForbidden},
+		{name: "missing VM", status: http.StatusInternalServerError, body: "Configuration file 'nodes/pve-1/qemu-server/100.conf' does not exist", want: ErrorNotFound},
+		{name: "locked VM", status: http.StatusInternalServerError, body: "VM is locked (backup)", want: ErrorVMLocked},
+		{name: "node unreachable", status: proxmoxStatusProxyError, body: "No route to host", want: ErrorUnreachable},
+		{name: "other failure", status: http.StatusInternalServerError, body: "unexpected", want: ErrorUnknown},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, tc.body, tc.status)
+			}))
+			defer server.Close()
+
+			client := proxmox.NewClient(server.URL, proxmox.WithHTTPClient(&http.Client{
+				Transport: &statusTransport{next: http.DefaultTransport},
+			}))
+
+			_, err := client.Version(t.Context())
+			require.Error(t, err)
+			assert.Equal(t, tc.want, KindOf(classify(err)))
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	assert.NoError(t, classify(nil))
+	assert.Equal(t, ErrorTimeout, KindOf(classify(fmt.Errorf("failed to get nodes: %w", context.DeadlineExceeded))))
+	assert.Equal(t, ErrorUnreachable, KindOf(classify(fmt.Errorf("%w: pve-1", errNoAvailableHost))))
+	assert.Equal(t, ErrorUnauthorized, KindOf(&InvalidCredentialsError{Username: "root@pam"}))
+
+	typed := &Error{Kind: ErrorVMLocked, Err: assert.AnError}
+	assert.Same(t, typed, classify(typed), "typed errors are kept")
+}
+
+func TestClientPool_UpdateVMNameErrors(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+	pool := newTestClientPool(t, f)
+
+	err := pool.UpdateVMName(t.Context(), "pve-1", 101, "renamed")
+	assert.Equal(t, ErrorNotFound, KindOf(err))
+
+	f.mu.Lock()
+	f.taskExitStatus = "unable to write config"
+	f.mu.Unlock()
+	err = pool.UpdateVMName(t.Context(), "pve-1", 100, "renamed")
+	assert.Equal(t, ErrorTaskFailed, KindOf(err))
+	assert.ErrorContains(t, err, "unable to write config")
+}
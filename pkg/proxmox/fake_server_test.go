@@ -0,0 +1,393 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeVM struct {
+	ID int
+	// Type defaults to GuestTypeQEMU.
+	Type   GuestType
+	Name   string
+	SMBIOS string
+	Net0   string
+	// Agent is the agent config option; the guest agent reports Hostname
+	// and IPs, or never answers when AgentHangs is set. Containers report
+	// Hostname in their config.
+	Agent       string
+	Hostname    string
+	IPs         []string
+	AgentHangs  bool
+	Stopped     bool
+	Template    bool
+	Lock        string
+	Description string
+}
+
+// fakeProxmox serves the subset of the Proxmox API used by ClientPool.
+type fakeProxmox struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	nodes    map[string][]fakeVM
+	order    []string
+	offline  map[string]bool
+	failing  map[string]bool
+	latency  time.Duration
+	requests atomic.Int64
+	paths    sync.Map
+	// inFlight and maxInFlight count concurrent requests.
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+
+	// password enables ticket authentication when set.
+	password string
+	tickets  map[string]bool
+	issued   int
+	// totpKey requires a TOTP code after the password when set.
+	totpKey []byte
+	// taskExitStatus overrides the exit status of every task.
+	taskExitStatus string
+	// unavailable answers the next n requests to "METHOD path" with 503.
+	unavailable map[string]int
+}
+
+func newFakeProxmox(t testing.TB) *fakeProxmox {
+	t.Helper()
+
+	f := &fakeProxmox{
+		nodes:       make(map[string][]fakeVM),
+		offline:     make(map[string]bool),
+		failing:     make(map[string]bool),
+		tickets:     make(map[string]bool),
+		unavailable: make(map[string]int),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+// testUUID returns a valid SMBIOS UUID derived from n.
+func testUUID(n int) string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", n)
+}
+
+func (f *fakeProxmox) addVM(node string, vm fakeVM) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.nodes[node]; !ok {
+		f.order = append(f.order, node)
+	}
+	f.nodes[node] = append(f.nodes[node], vm)
+}
+
+// expireTickets invalidates every issued ticket, as a pveproxy restart with a new key would.
+func (f *fakeProxmox) expireTickets() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tickets = make(map[string]bool)
+}
+
+// requestCount returns how often path was requested with GET.
+func (f *fakeProxmox) requestCount(path string) int64 {
+	return f.methodCount(http.MethodGet, path)
+}
+
+func (f *fakeProxmox) methodCount(method, path string) int64 {
+	v, ok := f.paths.Load(method + " " + path)
+	if !ok {
+		return 0
+	}
+
+	return v.(*atomic.Int64).Load()
+}
+
+func (f *fakeProxmox) handle(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+	counter, _ := f.paths.LoadOrStore(r.Method+" "+r.URL.Path, &atomic.Int64{})
+	counter.(*atomic.Int64).Add(1)
+
+	inFlight := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for peak := f.maxInFlight.Load(); inFlight > peak && !f.maxInFlight.CompareAndSwap(peak, inFlight); {
+		peak = f.maxInFlight.Load()
+	}
+
+	if f.latency > 0 {
+		time.Sleep(f.latency)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key := r.Method + " " + r.URL.Path; f.unavailable[key] > 0 {
+		f.unavailable[key]--
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if f.password != "" && !f.authenticate(w, r) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) > 1 && parts[0] == "nodes" && (f.offline[parts[1]] || f.failing[parts[1]]) {
+		http.Error(w, "no route to host", proxmoxStatusProxyError)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/version":
+		f.reply(w, map[string]string{"version": "8.2.2"})
+	case r.URL.Path == "/nodes":
+		nodes := make([]map[string]any, 0, len(f.order))
+		for _, name := range f.order {
+			status := "online"
+			if f.offline[name] {
+				status = "offline"
+			}
+			nodes = append(nodes, map[string]any{"node": name, "status": status})
+		}
+		f.reply(w, nodes)
+	case r.URL.Path == "/cluster/resources":
+		var resources []map[string]any
+		for _, name := range f.order {
+			status := "running"
+			if f.offline[name] {
+				status = "unknown"
+			}
+			for _, vm := range f.nodes[name] {
+				resource := map[string]any{
+					"id": fmt.Sprintf("%s/%d", vm.guestType(), vm.ID), "type": vm.guestType(), "node": name,
+					"vmid": vm.ID, "name": vm.Name, "status": vm.status(status),
+				}
+				if vm.Lock != "" {
+					resource["lock"] = vm.Lock
+				}
+				resources = append(resources, resource)
+			}
+		}
+		f.reply(w, resources)
+	case len(parts) == 3 && parts[0] == "nodes" && parts[2] == "status":
+		f.reply(w, map[string]any{"uptime": 1})
+	case len(parts) == 3 && parts[0] == "nodes" && (parts[2] == "qemu" || parts[2] == "lxc"):
+		vms := []map[string]any{}
+		for _, vm := range f.nodes[parts[1]] {
+			if string(vm.guestType()) == parts[2] {
+				vms = append(vms, map[string]any{"vmid": vm.ID, "name": vm.Name, "status": vm.status("running")})
+			}
+		}
+		f.reply(w, vms)
+	case len(parts) >= 5 && parts[0] == "nodes" && (parts[2] == "qemu" || parts[2] == "lxc"):
+		vm, ok := f.findVM(parts[1], parts[3])
+		if !ok || string(vm.guestType()) != parts[2] {
+			http.Error(w, "Configuration file does not exist", http.StatusInternalServerError)
+			return
+		}
+		if vm.guestType() == GuestTypeLXC {
+			f.handleContainer(w, r, vm, strings.Join(parts[4:], "/"))
+			return
+		}
+		f.handleVM(w, r, parts[1], vm, strings.Join(parts[4:], "/"))
+	case len(parts) == 5 && parts[0] == "nodes" && parts[2] == "tasks":
+		exitStatus := "OK"
+		if f.taskExitStatus != "" {
+			exitStatus = f.taskExitStatus
+		}
+		f.reply(w, map[string]any{"status": "stopped", "exitstatus": exitStatus})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authenticate handles logins and reports whether the request carries a valid ticket.
+func (f *fakeProxmox) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path == "/access/ticket" && r.Method == http.MethodPost {
+		var credentials map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&credentials)
+
+		f.issued++
+		switch challenge := credentials["tfa-challenge"]; {
+		case challenge != "":
+			// Like Proxmox, accept the code of the previous period as well.
+			now := time.Now()
+			valid := credentials["password"] == "totp:"+totpCode(f.totpKey, now, totpDigits) ||
+				credentials["password"] == "totp:"+totpCode(f.totpKey, now.Add(-totpPeriod), totpDigits)
+			if !f.tickets[challenge] || !valid {
+				http.Error(w, "authentication failure", http.StatusUnauthorized)
+				return false
+			}
+			delete(f.tickets, challenge)
+		case f.tickets[credentials["password"]]:
+		case credentials["password"] != f.password:
+			http.Error(w, "authentication failure", http.StatusUnauthorized)
+			return false
+		case f.totpKey != nil:
+			ticket := fmt.Sprintf("PVE:!tfa!%s:%08X::signature", credentials["username"], f.issued)
+			f.tickets[ticket] = true
+			f.reply(w, map[string]any{"ticket": ticket, "CSRFPreventionToken": "csrf", "username": credentials["username"], "NeedTFA": 1})
+			return false
+		}
+
+		ticket := fmt.Sprintf("PVE:%s:%08X::signature", credentials["username"], f.issued)
+		f.tickets[ticket] = true
+		f.reply(w, map[string]string{"ticket": ticket, "CSRFPreventionToken": "csrf", "username": credentials["username"]})
+		return false
+	}
+
+	cookie, err := r.Cookie("PVEAuthCookie")
+	if err != nil || !f.tickets[cookie.Value] || strings.Contains(cookie.Value, "!tfa!") {
+		http.Error(w, "permission denied - invalid PVE ticket", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+func (f *fakeProxmox) handleVM(w http.ResponseWriter, r *http.Request, node string, vm *fakeVM, sub string) {
+	switch {
+	case sub == "status/current":
+		f.reply(w, map[string]any{"vmid": vm.ID, "name": vm.Name, "status": vm.status("running")})
+	case sub == "config" && r.Method == http.MethodGet:
+		config := map[string]any{"name": vm.Name, "smbios1": vm.SMBIOS, "digest": fmt.Sprintf("%x", len(vm.Name))}
+		if vm.Net0 != "" {
+			config["net0"] = vm.Net0
+		}
+		if vm.Agent != "" {
+			config["agent"] = vm.Agent
+		}
+		vm.addFlags(config)
+		f.reply(w, config)
+	case strings.HasPrefix(sub, "agent/") && !agentEnabled(vm.Agent):
+		http.Error(w, "No QEMU guest agent configured", http.StatusInternalServerError)
+	case strings.HasPrefix(sub, "agent/") && vm.AgentHangs:
+		// Let other requests through while this one hangs.
+		f.mu.Unlock()
+		<-r.Context().Done()
+		f.mu.Lock()
+	case sub == "agent/get-host-name":
+		f.reply(w, map[string]any{"result": map[string]any{"host-name": vm.Hostname}})
+	case sub == "agent/network-get-interfaces":
+		addresses := []map[string]any{{"ip-address": "127.0.0.1", "ip-address-type": "ipv4"}}
+		for _, ip := range vm.IPs {
+			addresses = append(addresses, map[string]any{"ip-address": ip})
+		}
+		f.reply(w, map[string]any{"result": []map[string]any{{"name": "eth0", "ip-addresses": addresses}}})
+	case sub == "config" && r.Method == http.MethodPost:
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err == nil {
+			if name, ok := body["name"].(string); ok {
+				vm.Name = name
+			}
+			if description, ok := body["description"].(string); ok {
+				vm.Description = description
+			}
+		}
+		f.reply(w, fmt.Sprintf("UPID:%s:00000001:00000001:00000001:qmconfig:%d:root@pam:", node, vm.ID))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeProxmox) handleContainer(w http.ResponseWriter, r *http.Request, vm *fakeVM, sub string) {
+	switch {
+	case sub == "config" && r.Method == http.MethodGet:
+		config := map[string]any{"hostname": vm.Hostname, "digest": fmt.Sprintf("%x", len(vm.Hostname))}
+		if vm.Net0 != "" {
+			config["net0"] = vm.Net0
+		}
+		vm.addFlags(config)
+		f.reply(w, config)
+	case sub == "config" && r.Method == http.MethodPut:
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err == nil {
+			if hostname, ok := body["hostname"].(string); ok {
+				vm.Hostname = hostname
+				vm.Name = hostname
+			}
+			if description, ok := body["description"].(string); ok {
+				vm.Description = description
+			}
+		}
+		f.reply(w, nil)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (vm *fakeVM) addFlags(config map[string]any) {
+	if vm.Description != "" {
+		config["description"] = vm.Description
+	}
+	if vm.Template {
+		config["template"] = 1
+	}
+	if vm.Lock != "" {
+		config["lock"] = vm.Lock
+	}
+}
+
+// status returns "stopped" for stopped VMs and nodeStatus otherwise.
+func (vm *fakeVM) status(nodeStatus string) string {
+	if vm.Stopped && nodeStatus == "running" {
+		return "stopped"
+	}
+
+	return nodeStatus
+}
+
+func (vm *fakeVM) guestType() GuestType {
+	if vm.Type == "" {
+		return GuestTypeQEMU
+	}
+
+	return vm.Type
+}
+
+func (f *fakeProxmox) findVM(node, rawID string) (*fakeVM, bool) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		return nil, false
+	}
+
+	for i := range f.nodes[node] {
+		if f.nodes[node][i].ID == id {
+			return &f.nodes[node][i], true
+		}
+	}
+
+	return nil, false
+}
+
+func (f *fakeProxmox) reply(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func newTestClientPool(t testing.TB, f *fakeProxmox) *ClientPool {
+	t.Helper()
+
+	pool, err := NewClientPool(&ClusterConfig{
+		Name:     "test",
+		HostURLs: []string{f.URL},
+		TokenID:  "test@pve!test",
+		Secret:   "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pool
+}
@@ -0,0 +1,260 @@
+package proxmox
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+var errNoAvailableHost = errors.New("no available Proxmox host")
+
+type SelectionPolicy string
+
+// Valid reports whether p is a known policy; the empty policy selects the default.
+func (p SelectionPolicy) Valid() bool {
+	switch p {
+	case "", SelectionOrdered, SelectionRoundRobin, SelectionLowestLatency:
+		return true
+	default:
+		return false
+	}
+}
+
+const (
+	// SelectionOrdered uses the first available host in priority order.
+	SelectionOrdered SelectionPolicy = "ordered"
+	// SelectionRoundRobin spreads calls over all available hosts.
+	SelectionRoundRobin SelectionPolicy = "round-robin"
+	// SelectionLowestLatency uses the available host with the lowest health check latency.
+	SelectionLowestLatency SelectionPolicy = "lowest-latency"
+)
+
+const (
+	defaultHealthCheckInterval = 30 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+	defaultFailureThreshold    = 3
+	defaultCircuitBackoff      = time.Minute
+)
+
+type HealthCheckConfig struct {
+	// Interval is how often every host is probed.
+	Interval Duration `json:"interval"`
+	// Timeout bounds a single probe.
+	Timeout Duration `json:"timeout"`
+	// FailureThreshold is the number of consecutive failures after which a
+	// host is taken out of rotation.
+	FailureThreshold int `json:"failureThreshold"`
+	// Backoff is how long a host stays out of rotation before it is tried again.
+	Backoff Duration `json:"backoff"`
+}
+
+func (c HealthCheckConfig) withDefaults() HealthCheckConfig {
+	c.Interval.Duration = durationOrDefault(c.Interval, defaultHealthCheckInterval)
+	c.Timeout.Duration = durationOrDefault(c.Timeout, defaultHealthCheckTimeout)
+	c.Backoff.Duration = durationOrDefault(c.Backoff, defaultCircuitBackoff)
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = defaultFailureThreshold
+	}
+
+	return c
+}
+
+// host is a single Proxmox API endpoint together with its circuit breaker.
+// Every request made through the host's HTTP client updates the breaker.
+type host struct {
+	url    string
+	client *proxmox.Client
+
+	failureThreshold int
+	backoff          time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	latency   time.Duration
+	lastErr   error
+}
+
+// available reports whether the circuit of the host is closed, or its backoff
+// has passed and it may be tried again.
+func (h *host) available(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return !now.Before(h.openUntil)
+}
+
+func (h *host) recordSuccess() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.failures >= h.failureThreshold {
+		slog.Info("Proxmox host recovered", "host", h.url)
+	}
+	h.failures = 0
+	h.openUntil = time.Time{}
+	h.lastErr = nil
+}
+
+func (h *host) recordFailure(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.failures++
+	h.lastErr = err
+	if h.failures >= h.failureThreshold {
+		if h.failures == h.failureThreshold {
+			slog.Warn("Proxmox host is failing, taking it out of rotation", "host", h.url, "backoff", h.backoff, "error", err)
+		}
+		h.openUntil = time.Now().Add(h.backoff)
+	}
+}
+
+func (h *host) recordLatency(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latency = latency
+}
+
+// rankedLatency returns the last health check latency of the host, or the
+// longest duration when no health check has succeeded yet, so that unmeasured
+// hosts rank behind every measured one.
+func (h *host) rankedLatency() time.Duration {
+	latency, _ := h.state()
+	if latency == 0 {
+		return math.MaxInt64
+	}
+
+	return latency
+}
+
+func (h *host) state() (time.Duration, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.latency, h.lastErr
+}
+
+// healthTransport reports connection failures and gateway errors of a host
+// to its circuit breaker. Other error responses come from a reachable
+// Proxmox API and do not count against the host, and neither do requests
+// aborted because the caller's context was cancelled or its deadline passed.
+type healthTransport struct {
+	next http.RoundTripper
+	host *host
+}
+
+func (t *healthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil:
+		if req.Context().Err() == nil {
+			t.host.recordFailure(err)
+		}
+	case res.StatusCode == http.StatusBadGateway ||
+		res.StatusCode == http.StatusServiceUnavailable ||
+		res.StatusCode == http.StatusGatewayTimeout:
+		t.host.recordFailure(fmt.Errorf("unexpected response: %s", res.Status))
+	default:
+		t.host.recordSuccess()
+	}
+
+	return res, err
+}
+
+// runHealthChecks probes every host until ctx is cancelled.
+func (c *ClientPool) runHealthChecks(ctx context.Context) error {
+	c.checkHosts(ctx)
+
+	ticker := time.NewTicker(c.healthCheck.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.checkHosts(ctx)
+		}
+	}
+}
+
+func (c *ClientPool) checkHosts(ctx context.Context) {
+	var wg sync.WaitGroup
+	now := time.Now()
+	for _, h := range c.hosts {
+		// Hosts with an open circuit are only probed once their backoff has passed.
+		if !h.available(now) {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.checkHost(ctx, h)
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *ClientPool) checkHost(ctx context.Context, h *host) {
+	ctx, cancel := context.WithTimeout(ctx, c.healthCheck.Timeout.Duration)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := h.client.Version(ctx); err != nil {
+		slog.Debug("Proxmox host health check failed", "host", h.url, "error", err)
+		// The transport ignores requests that outlive their context, but a
+		// probe that times out does count against the host.
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			h.recordFailure(err)
+		}
+		return
+	}
+
+	h.recordLatency(time.Since(start))
+}
+
+// getClient returns the client of an available host chosen by the selection
+// policy. It does not contact Proxmox; host health is tracked by the health
+// checks and by the outcome of every request.
+func (c *ClientPool) getClient(_ context.Context) (*proxmox.Client, error) {
+	now := time.Now()
+	candidates := make([]*host, 0, len(c.hosts))
+	for _, h := range c.hosts {
+		if h.available(now) {
+			candidates = append(candidates, h)
+		}
+	}
+
+	if len(candidates) == 0 {
+		errs := make([]error, 0, len(c.hosts))
+		for _, h := range c.hosts {
+			_, lastErr := h.state()
+			errs = append(errs, fmt.Errorf("%s: %w", h.url, lastErr))
+		}
+		return nil, fmt.Errorf("%w: %w", errNoAvailableHost, errors.Join(errs...))
+	}
+
+	switch c.selectionPolicy {
+	case SelectionRoundRobin:
+		next := c.roundRobin.Add(1) - 1
+		return candidates[next%uint64(len(candidates))].client, nil
+	case SelectionLowestLatency:
+		return slices.MinFunc(candidates, func(a, b *host) int {
+			return cmp.Compare(a.rankedLatency(), b.rankedLatency())
+		}).client, nil
+	default:
+		return candidates[0].client, nil
+	}
+}
@@ -0,0 +1,113 @@
+package proxmox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHostPool(t *testing.T, policy SelectionPolicy, urls ...string) *ClientPool {
+	t.Helper()
+
+	pool, err := NewClientPool(&ClusterConfig{
+		HostURLs:        urls,
+		TokenID:         "test@pve!test",
+		Secret:          "secret",
+		SelectionPolicy: policy,
+		HealthCheck: HealthCheckConfig{
+			FailureThreshold: 2,
+			Backoff:          Duration{Duration: time.Hour},
+		},
+	})
+	require.NoError(t, err)
+
+	return pool
+}
+
+func TestClientPool_CircuitBreaker(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	healthy := newFakeProxmox(t)
+
+	pool := newTestHostPool(t, SelectionOrdered, dead.URL, healthy.URL)
+
+	client, err := pool.getClient(t.Context())
+	require.NoError(t, err)
+	assert.Same(t, pool.hosts[0].client, client, "hosts are assumed healthy until a call fails")
+
+	pool.checkHosts(t.Context())
+	pool.checkHosts(t.Context())
+
+	client, err = pool.getClient(t.Context())
+	require.NoError(t, err)
+	assert.Same(t, pool.hosts[1].client, client, "the failing host is out of rotation")
+
+	// Probes skip hosts whose circuit is open.
+	pool.checkHosts(t.Context())
+	assert.Equal(t, 2, pool.hosts[0].failures)
+
+	pool.hosts[1].recordFailure(assert.AnError)
+	pool.hosts[1].recordFailure(assert.AnError)
+	_, err = pool.getClient(t.Context())
+	assert.ErrorContains(t, err, "no available Proxmox host")
+}
+
+func TestClientPool_CallerDeadline(t *testing.T) {
+	slow := newFakeProxmox(t)
+	slow.latency = 50 * time.Millisecond
+	pool := newTestHostPool(t, SelectionOrdered, slow.URL)
+
+	for range 3 {
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Millisecond)
+		_, err := pool.hosts[0].client.Version(ctx)
+		cancel()
+		require.Error(t, err)
+	}
+
+	_, err := pool.getClient(t.Context())
+	require.NoError(t, err, "expired caller deadlines do not open the circuit")
+
+	pool.healthCheck.Timeout.Duration = 5 * time.Millisecond
+	pool.checkHosts(t.Context())
+	pool.checkHosts(t.Context())
+	_, err = pool.getClient(t.Context())
+	assert.ErrorContains(t, err, "no available Proxmox host", "timed out probes do")
+}
+
+func TestClientPool_SelectionPolicies(t *testing.T) {
+	urls := []string{"https://pve-1:8006", "https://pve-2:8006", "https://pve-3:8006"}
+
+	t.Run("round-robin", func(t *testing.T) {
+		pool := newTestHostPool(t, SelectionRoundRobin, urls...)
+		for i := range 6 {
+			client, err := pool.getClient(t.Context())
+			require.NoError(t, err)
+			assert.Same(t, pool.hosts[i%3].client, client)
+		}
+	})
+
+	t.Run("lowest-latency", func(t *testing.T) {
+		pool := newTestHostPool(t, SelectionLowestLatency, urls...)
+		pool.hosts[0].recordLatency(30 * time.Millisecond)
+		pool.hosts[1].recordLatency(5 * time.Millisecond)
+		pool.hosts[2].recordLatency(10 * time.Millisecond)
+
+		client, err := pool.getClient(t.Context())
+		require.NoError(t, err)
+		assert.Same(t, pool.hosts[1].client, client)
+	})
+
+	t.Run("lowest-latency skips unmeasured hosts", func(t *testing.T) {
+		pool := newTestHostPool(t, SelectionLowestLatency, urls...)
+		pool.hosts[2].recordLatency(10 * time.Millisecond)
+
+		client, err := pool.getClient(t.Context())
+		require.NoError(t, err)
+		assert.Same(t, pool.hosts[2].client, client)
+	})
+}
@@ -0,0 +1,179 @@
+package proxmox
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/luthermonson/go-proxmox"
+	"golang.org/x/time/rate"
+)
+
+// HostConfig describes a single Proxmox API endpoint. Credentials and TLS
+// settings that are left empty are inherited from the ClusterConfig.
+type HostConfig struct {
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// TOTPSecret is the base32 secret of the user's TOTP factor, used to
+	// answer the two-factor challenge on login.
+	TOTPSecret string `json:"totpSecret,omitempty"`
+	TokenID    string `json:"tokenId,omitempty"`
+	Secret     string `json:"secret,omitempty"`
+
+	Insecure *bool `json:"insecure,omitempty"`
+	// CABundle is a PEM encoded bundle of CA certificates trusted for this host.
+	CABundle string `json:"caBundle,omitempty"`
+	// CABundleFile is the path of a PEM encoded CA bundle, used instead of CABundle.
+	CABundleFile string `json:"caBundleFile,omitempty"`
+	// Fingerprint pins the SHA-256 fingerprint of the host certificate, as shown
+	// by Proxmox, e.g. "AB:CD:...". Without a CA bundle the pin replaces the
+	// usual chain verification, which suits the self-signed PVE certificate.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// ServerName overrides the name used to verify the host certificate.
+	ServerName string `json:"serverName,omitempty"`
+
+	// Timeout bounds every HTTP request made to this host.
+	Timeout Duration `json:"timeout,omitempty"`
+	// Priority orders the hosts; lower values are tried first.
+	Priority int `json:"priority,omitempty"`
+	// RateLimit applies to this host in addition to the cluster's limit.
+	RateLimit RateLimitConfig `json:"rateLimit,omitempty"`
+}
+
+func (h HostConfig) hasTokenAuth() bool {
+	return h.TokenID != "" && h.Secret != ""
+}
+
+func (h HostConfig) hasPasswordAuth() bool {
+	return h.Username != "" && h.Password != ""
+}
+
+// EffectiveHosts returns the hosts of the cluster, including the ones from
+// the legacy HostURLs list, with cluster level settings filled in and sorted
+// by priority.
+func (c *ClusterConfig) EffectiveHosts() []HostConfig {
+	hosts := make([]HostConfig, 0, len(c.Hosts)+len(c.HostURLs))
+	hosts = append(hosts, c.Hosts...)
+	for _, hostURL := range c.HostURLs {
+		hosts = append(hosts, HostConfig{URL: hostURL})
+	}
+
+	for i := range hosts {
+		host := &hosts[i]
+		if !host.hasTokenAuth() && !host.hasPasswordAuth() {
+			host.Username = c.Username
+			host.Password = c.Password
+			host.TOTPSecret = c.TOTPSecret
+			host.TokenID = c.TokenID
+			host.Secret = c.Secret
+		}
+		if host.Insecure == nil {
+			insecure := c.Insecure
+			host.Insecure = &insecure
+		}
+		if host.CABundle == "" && host.CABundleFile == "" {
+			host.CABundle = c.CABundle
+			host.CABundleFile = c.CABundleFile
+		}
+	}
+
+	slices.SortStableFunc(hosts, func(a, b HostConfig) int {
+		return a.Priority - b.Priority
+	})
+
+	return hosts
+}
+
+// ValidateHost checks that a host entry can be turned into a client.
+func ValidateHost(host HostConfig) error {
+	if host.URL == "" {
+		return errors.New("url is required")
+	}
+
+	parsedURL, err := url.Parse(host.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if parsedURL.Scheme != "https" && parsedURL.Scheme != "http" {
+		return fmt.Errorf("url %q must use http or https", host.URL)
+	}
+
+	if !host.hasTokenAuth() && !host.hasPasswordAuth() {
+		return errors.New("authentication credentials are required (token or username/password)")
+	}
+
+	if host.TOTPSecret != "" {
+		if !host.hasPasswordAuth() {
+			return errors.New("totpSecret requires username/password authentication")
+		}
+		if _, err := decodeTOTPSecret(host.TOTPSecret); err != nil {
+			return err
+		}
+	}
+
+	if host.Timeout.Duration < 0 {
+		return errors.New("timeout must not be negative")
+	}
+
+	if err := host.RateLimit.validate(); err != nil {
+		return err
+	}
+
+	if _, err := hostTLSConfig(host); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func newHost(cluster string, cfg HostConfig, healthCheck HealthCheckConfig, clusterLimiter *rate.Limiter) (*host, error) {
+	parsedURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Proxmox URL: %w", err)
+	}
+
+	tlsConfig, err := hostTLSConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS settings for %s: %w", cfg.URL, err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	h := &host{
+		url:              cfg.URL,
+		failureThreshold: healthCheck.FailureThreshold,
+		backoff:          healthCheck.Backoff.Duration,
+	}
+	var roundTripper http.RoundTripper = &healthTransport{next: transport, host: h}
+	roundTripper = newRateLimitTransport(roundTripper, cluster, cfg.URL, clusterLimiter, cfg.RateLimit.newLimiter())
+
+	var opts []proxmox.Option
+	switch {
+	case cfg.hasTokenAuth():
+		opts = append(opts, proxmox.WithAPIToken(cfg.TokenID, cfg.Secret))
+	case cfg.hasPasswordAuth():
+		// Session tickets are handled by the transport rather than by go-proxmox,
+		// which neither renews tickets nor reports rejected credentials.
+		var totpKey []byte
+		if cfg.TOTPSecret != "" {
+			if totpKey, err = decodeTOTPSecret(cfg.TOTPSecret); err != nil {
+				return nil, err
+			}
+		}
+		roundTripper = newSessionTransport(roundTripper, parsedURL.String(), cfg.Username, cfg.Password, totpKey)
+	default:
+		return nil, fmt.Errorf("either API token (TokenID and Secret) or credentials (Username and Password) must be provided")
+	}
+
+	roundTripper = &statusTransport{next: roundTripper}
+	opts = append(opts, proxmox.WithHTTPClient(&http.Client{Timeout: cfg.Timeout.Duration, Transport: roundTripper}))
+	h.client = proxmox.NewClient(parsedURL.String(), opts...)
+
+	return h, nil
+}
@@ -0,0 +1,27 @@
+package proxmox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/ptr"
+)
+
+func TestClusterConfig_EffectiveHosts(t *testing.T) {
+	cfg := ClusterConfig{
+		HostURLs: []string{"https://legacy:8006"},
+		TokenID:  "cluster@pve!token",
+		Secret:   "cluster-secret",
+		Insecure: true,
+		Hosts: []HostConfig{
+			{URL: "https://backup:8006", Priority: 10},
+			{URL: "https://primary:8006", Username: "root@pam", Password: "pw", Insecure: ptr.To(false), Priority: -1},
+		},
+	}
+
+	assert.Equal(t, []HostConfig{
+		{URL: "https://primary:8006", Username: "root@pam", Password: "pw", Insecure: ptr.To(false), Priority: -1},
+		{URL: "https://legacy:8006", TokenID: "cluster@pve!token", Secret: "cluster-secret", Insecure: ptr.To(true)},
+		{URL: "https://backup:8006", TokenID: "cluster@pve!token", Secret: "cluster-secret", Insecure: ptr.To(true), Priority: 10},
+	}, cfg.EffectiveHosts())
+}
@@ -0,0 +1,318 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInventoryRefreshInterval    = 5 * time.Minute
+	defaultInventoryMinRefreshInterval = 30 * time.Second
+)
+
+type InventoryConfig struct {
+	// RefreshInterval is how often the whole VM inventory is re-read from Proxmox.
+	RefreshInterval Duration `json:"refreshInterval"`
+	// MinRefreshInterval limits how often a lookup miss may trigger an
+	// on-demand refresh.
+	MinRefreshInterval Duration `json:"minRefreshInterval"`
+	// ConfigCacheTTL is how long a VM's config is reused during discovery
+	// before it is read again.
+	ConfigCacheTTL Duration `json:"configCacheTtl"`
+	// IncludeTemplates makes VM and container templates matchable. Templates
+	// are skipped by default, as clones whose SMBIOS UUID was not regenerated
+	// share it with their template.
+	IncludeTemplates bool `json:"includeTemplates"`
+}
+
+// inventory keeps an in-memory index of the VMs and containers in a Proxmox
+// cluster by UUID, hostname and MAC address so that lookups do not have to
+// scan the cluster on every reconcile.
+type inventory struct {
+	list               func(ctx context.Context) ([]VM, error)
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	includeTemplates   bool
+
+	// refreshMu serializes refreshes so that concurrent lookups share a single scan.
+	refreshMu sync.Mutex
+
+	mu  sync.RWMutex
+	vms []VM
+	// index maps idKey, uuidKey, hostnameKey and macKey values to VMs.
+	index map[string]VM
+	// duplicates maps UUIDs shared by more than one VM to those VMs.
+	duplicates  map[string][]VM
+	invalidated map[string]struct{}
+	refreshedAt time.Time
+	// partial is set when the last refresh could not list every node.
+	partial *PartialResultError
+}
+
+func newInventory(cfg InventoryConfig, list func(ctx context.Context) ([]VM, error)) *inventory {
+	return &inventory{
+		list:               list,
+		refreshInterval:    durationOrDefault(cfg.RefreshInterval, defaultInventoryRefreshInterval),
+		minRefreshInterval: durationOrDefault(cfg.MinRefreshInterval, defaultInventoryMinRefreshInterval),
+		includeTemplates:   cfg.IncludeTemplates,
+		index:              make(map[string]VM),
+		invalidated:        make(map[string]struct{}),
+	}
+}
+
+// run refreshes the inventory every refreshInterval until ctx is cancelled.
+func (i *inventory) run(ctx context.Context) error {
+	if err := i.refresh(ctx); err != nil {
+		slog.Error("Failed to refresh VM inventory", "error", err)
+	}
+
+	ticker := time.NewTicker(i.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := i.refresh(ctx); err != nil {
+				slog.Error("Failed to refresh VM inventory", "error", err)
+			}
+		}
+	}
+}
+
+func (i *inventory) refresh(ctx context.Context) error {
+	i.refreshMu.Lock()
+	defer i.refreshMu.Unlock()
+
+	return i.refreshLocked(ctx)
+}
+
+func (i *inventory) refreshLocked(ctx context.Context) error {
+	vms, err := i.list(ctx)
+	var partial *PartialResultError
+	if err != nil && !errors.As(err, &partial) {
+		return err
+	}
+
+	if !i.includeTemplates {
+		vms = slices.DeleteFunc(slices.Clone(vms), func(vm VM) bool { return vm.Template })
+	}
+
+	index := make(map[string]VM, len(vms))
+	byUUID := make(map[string][]VM)
+	for _, vm := range vms {
+		for _, key := range indexKeys(vm) {
+			index[key] = vm
+		}
+		if vm.UUID != "" {
+			byUUID[vm.UUID] = append(byUUID[vm.UUID], vm)
+		}
+	}
+
+	duplicates := make(map[string][]VM)
+	for uuid, shared := range byUUID {
+		if len(shared) > 1 {
+			vmids := make([]int, 0, len(shared))
+			for _, vm := range shared {
+				vmids = append(vmids, vm.ID)
+			}
+			slog.Warn("VMs share a UUID and cannot be matched by it", "uuid", uuid, "vmids", vmids)
+			duplicates[uuid] = shared
+		}
+	}
+
+	i.mu.Lock()
+	i.vms = vms
+	i.index = index
+	i.duplicates = duplicates
+	i.invalidated = make(map[string]struct{})
+	i.refreshedAt = time.Now()
+	i.partial = partial
+	i.mu.Unlock()
+
+	slog.Debug("Refreshed VM inventory", "vms", len(vms))
+	return nil
+}
+
+func uuidKey(uuid string) string         { return "uuid/" + uuid }
+func macKey(mac string) string           { return "mac/" + mac }
+func hostnameKey(hostname string) string { return "hostname/" + hostname }
+func idKey(vmid int) string              { return "id/" + strconv.Itoa(vmid) }
+
+func indexKeys(vm VM) []string {
+	keys := []string{idKey(vm.ID)}
+	if vm.UUID != "" {
+		keys = append(keys, uuidKey(vm.UUID))
+	}
+	if vm.Hostname != "" {
+		keys = append(keys, hostnameKey(vm.Hostname))
+	}
+	for _, mac := range vm.MACs {
+		keys = append(keys, macKey(mac))
+	}
+
+	return keys
+}
+
+// getByUUID looks up the VM with uuid like get, but fails with
+// ErrorDuplicateUUID instead of returning any one of several VMs sharing it.
+func (i *inventory) getByUUID(ctx context.Context, uuid string) (*VM, error) {
+	vm, err := i.get(ctx, uuidKey(uuid))
+	if vm == nil {
+		return nil, err
+	}
+
+	i.mu.RLock()
+	shared := i.duplicates[uuid]
+	i.mu.RUnlock()
+	if len(shared) > 1 {
+		return nil, newDuplicateUUIDError(uuid, shared)
+	}
+
+	return vm, err
+}
+
+// get serves a lookup from memory, refreshing first when the inventory is
+// older than refreshInterval, or the key is unknown and either an entry was
+// invalidated or no refresh happened within minRefreshInterval. When the last
+// refresh was partial, the *PartialResultError is returned alongside the result.
+func (i *inventory) get(ctx context.Context, key string) (*VM, error) {
+	if vm, ok := i.lookup(key); ok {
+		return vm, i.partialErr()
+	}
+
+	i.refreshMu.Lock()
+	defer i.refreshMu.Unlock()
+
+	// Another lookup may have refreshed while we were waiting for the lock.
+	if vm, ok := i.lookup(key); ok {
+		return vm, i.partialErr()
+	}
+	if !i.shouldRefresh() {
+		return nil, i.partialErr()
+	}
+
+	if err := i.refreshLocked(ctx); err != nil {
+		return nil, err
+	}
+
+	vm, _ := i.lookup(key)
+	return vm, i.partialErr()
+}
+
+func (i *inventory) partialErr() error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if i.partial == nil {
+		return nil
+	}
+
+	return i.partial
+}
+
+// all returns every VM in the inventory, refreshing first when the inventory
+// is older than refreshInterval or, after minRefreshInterval, has invalidated
+// entries.
+func (i *inventory) all(ctx context.Context) ([]VM, error) {
+	if vms, ok := i.current(); ok {
+		return vms, i.partialErr()
+	}
+
+	i.refreshMu.Lock()
+	defer i.refreshMu.Unlock()
+
+	if vms, ok := i.current(); ok {
+		return vms, i.partialErr()
+	}
+	if err := i.refreshLocked(ctx); err != nil {
+		return nil, err
+	}
+
+	i.mu.RLock()
+	vms := i.vms
+	i.mu.RUnlock()
+
+	return vms, i.partialErr()
+}
+
+func (i *inventory) current() ([]VM, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	age := time.Since(i.refreshedAt)
+	if age > i.refreshInterval || (len(i.invalidated) > 0 && age > i.minRefreshInterval) {
+		return nil, false
+	}
+
+	return i.vms, true
+}
+
+// lookup reports ok only for a fresh, valid hit; a fresh miss returns (nil, false).
+func (i *inventory) lookup(key string) (*VM, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if time.Since(i.refreshedAt) > i.refreshInterval {
+		return nil, false
+	}
+	if _, invalid := i.invalidated[key]; invalid {
+		return nil, false
+	}
+
+	vm, found := i.index[key]
+	if !found {
+		return nil, false
+	}
+
+	return &vm, true
+}
+
+func (i *inventory) shouldRefresh() bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	age := time.Since(i.refreshedAt)
+	if age > i.refreshInterval {
+		return true
+	}
+	// A renamed container is looked up under its new hostname, which is
+	// not indexed yet, so any miss after an invalidation refreshes.
+	if len(i.invalidated) > 0 {
+		return true
+	}
+
+	return age > i.minRefreshInterval
+}
+
+// invalidate drops the index entries of the given VM so the next lookup by
+// any of its keys re-reads it from Proxmox. The VM stays in the list used by
+// scans such as GetVMsByName, with update applied when it is not nil, so that
+// a VM renamed by the controller is seen under its new name right away.
+func (i *inventory) invalidate(nodeName string, vmid int, update func(vm *VM)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for key, vm := range i.index {
+		if vm.Node == nodeName && vm.ID == vmid {
+			delete(i.index, key)
+			i.invalidated[key] = struct{}{}
+		}
+	}
+	if update == nil {
+		return
+	}
+
+	i.vms = slices.Clone(i.vms)
+	for n := range i.vms {
+		if i.vms[n].Node == nodeName && i.vms[n].ID == vmid {
+			update(&i.vms[n])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package proxmox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInventory_GetByUUID(t *testing.T) {
+	calls := 0
+	vms := []VM{
+		{ID: 100, Name: "vm-100", Node: "pve-1", UUID: "uuid-100"},
+		{ID: 101, Name: "vm-101", Node: "pve-2", UUID: "uuid-101"},
+	}
+	inv := newInventory(InventoryConfig{
+		RefreshInterval:    Duration{Duration: time.Hour},
+		MinRefreshInterval: Duration{Duration: time.Hour},
+	}, func(ctx context.Context) ([]VM, error) {
+		calls++
+		return vms, nil
+	})
+
+	vm, err := inv.getByUUID(t.Context(), "uuid-101")
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, 101, vm.ID)
+	assert.Equal(t, 1, calls, "first lookup loads the inventory")
+
+	vm, err = inv.getByUUID(t.Context(), "uuid-100")
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, 1, calls, "hits are served from memory")
+
+	vm, err = inv.getByUUID(t.Context(), "uuid-unknown")
+	require.NoError(t, err)
+	assert.Nil(t, vm)
+	assert.Equal(t, 1, calls, "misses within minRefreshInterval do not refresh")
+
+	inv.invalidate("pve-1", 100, nil)
+	vms[0].Name = "renamed"
+	vm, err = inv.getByUUID(t.Context(), "uuid-100")
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, "renamed", vm.Name)
+	assert.Equal(t, 2, calls, "invalidated entries are re-read")
+}
+
+func TestInventory_RefreshesWhenStale(t *testing.T) {
+	calls := 0
+	inv := newInventory(InventoryConfig{
+		RefreshInterval:    Duration{Duration: time.Hour},
+		MinRefreshInterval: Duration{Duration: time.Minute},
+	}, func(ctx context.Context) ([]VM, error) {
+		calls++
+		return []VM{{ID: 100, Node: "pve-1", UUID: "uuid-100"}}, nil
+	})
+
+	require.NoError(t, inv.refresh(t.Context()))
+	inv.refreshedAt = time.Now().Add(-2 * time.Hour)
+
+	vm, err := inv.getByUUID(t.Context(), "uuid-100")
+	require.NoError(t, err)
+	require.NotNil(t, vm)
+	assert.Equal(t, 2, calls)
+}
+
+func TestInventory_TemplatesAndDuplicateUUIDs(t *testing.T) {
+	template := VM{ID: 9000, Name: "template", Node: "pve-1", UUID: "uuid-a", Template: true}
+	clone := VM{ID: 100, Name: "vm-100", Node: "pve-1", UUID: "uuid-a"}
+	otherClone := VM{ID: 101, Name: "vm-101", Node: "pve-2", UUID: "uuid-a"}
+
+	tests := []struct {
+		name             string
+		vms              []VM
+		includeTemplates bool
+		wantID           int
+		wantDuplicates   []VM
+	}{
+		{name: "template is skipped", vms: []VM{template, clone}, wantID: 100},
+		{name: "included template is a duplicate", vms: []VM{template, clone}, includeTemplates: true, wantDuplicates: []VM{template, clone}},
+		{name: "clones are duplicates", vms: []VM{template, clone, otherClone}, wantDuplicates: []VM{clone, otherClone}},
+		{name: "only a template", vms: []VM{template}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			inv := newInventory(InventoryConfig{IncludeTemplates: tc.includeTemplates}, func(ctx context.Context) ([]VM, error) {
+				return tc.vms, nil
+			})
+
+			vm, err := inv.getByUUID(t.Context(), "uuid-a")
+			if tc.wantDuplicates != nil {
+				assert.Nil(t, vm)
+				assert.Equal(t, ErrorDuplicateUUID, KindOf(err))
+				var duplicate *DuplicateUUIDError
+				require.ErrorAs(t, err, &duplicate)
+				assert.Equal(t, tc.wantDuplicates, duplicate.VMs)
+				return
+			}
+			require.NoError(t, err)
+			if tc.wantID == 0 {
+				assert.Nil(t, vm)
+				return
+			}
+			require.NotNil(t, vm)
+			assert.Equal(t, tc.wantID, vm.ID)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package proxmox
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	rateLimitWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "proxmox_client_rate_limit_wait_seconds",
+		Help:    "Time Proxmox API requests waited for the client-side rate limiter.",
+		Buckets: []float64{0.001, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"cluster", "host"})
+
+	rateLimitThrottledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxmox_client_rate_limit_throttled_requests_total",
+		Help: "Number of Proxmox API requests delayed by the client-side rate limiter.",
+	}, []string{"cluster", "host"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(rateLimitWaitSeconds, rateLimitThrottledTotal)
+}
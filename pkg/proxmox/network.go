@@ -0,0 +1,57 @@
+package proxmox
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// NormalizeMAC returns mac as six lower case, colon separated octets. Colon,
+// dash and dot notations are accepted.
+func NormalizeMAC(mac string) (string, error) {
+	hw, err := net.ParseMAC(strings.TrimSpace(mac))
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("%q is not a MAC address", mac)
+	}
+
+	return hw.String(), nil
+}
+
+// vmMACAddresses returns the MAC addresses of the VM's network devices in
+// netX order. Entries look like "virtio=BC:24:11:2A:3B:4C,bridge=vmbr0".
+func vmMACAddresses(config *proxmox.VirtualMachineConfig) []string {
+	return macAddresses(config.MergeNets())
+}
+
+// macAddresses returns the first MAC address of each netX device in order.
+// Container entries look like "name=eth0,bridge=vmbr0,hwaddr=BC:24:11:2A:3B:4C".
+func macAddresses(nets map[string]string) []string {
+	devices := make([]string, 0, len(nets))
+	for device := range nets {
+		devices = append(devices, device)
+	}
+	// Sort net2 before net10.
+	slices.SortFunc(devices, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(a), len(b)), strings.Compare(a, b))
+	})
+
+	var macs []string
+	for _, device := range devices {
+		for option := range strings.SplitSeq(nets[device], ",") {
+			_, value, ok := strings.Cut(option, "=")
+			if !ok {
+				continue
+			}
+			if mac, err := NormalizeMAC(value); err == nil {
+				macs = append(macs, mac)
+				break
+			}
+		}
+	}
+
+	return macs
+}
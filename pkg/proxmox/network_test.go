@@ -0,0 +1,50 @@
+package proxmox
+
+import (
+	"testing"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeMAC(t *testing.T) {
+	tests := []struct {
+		mac     string
+		want    string
+		wantErr bool
+	}{
+		{mac: "BC:24:11:2A:3B:4C", want: "bc:24:11:2a:3b:4c"},
+		{mac: "bc-24-11-2a-3b-4c", want: "bc:24:11:2a:3b:4c"},
+		{mac: "bc24.112a.3b4c", want: "bc:24:11:2a:3b:4c"},
+		{mac: " bc:24:11:2a:3b:4c ", want: "bc:24:11:2a:3b:4c"},
+		{mac: "00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01", wantErr: true},
+		{mac: "bc:24:11:2a:3b", wantErr: true},
+		{mac: "", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.mac, func(t *testing.T) {
+			got, err := NormalizeMAC(tc.mac)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestVMMACAddresses(t *testing.T) {
+	config := &proxmox.VirtualMachineConfig{
+		Net0: "virtio=BC:24:11:00:00:00,bridge=vmbr0,firewall=1",
+		Net2: "bridge=vmbr1,e1000=bc:24:11:00:00:02",
+		Net9: "virtio=BC:24:11:00:00:09,bridge=vmbr0",
+		// No MAC assigned yet.
+		Net1: "virtio,bridge=vmbr0",
+	}
+
+	assert.Equal(t, []string{"bc:24:11:00:00:00", "bc:24:11:00:00:02", "bc:24:11:00:00:09"}, vmMACAddresses(config))
+	assert.Empty(t, vmMACAddresses(&proxmox.VirtualMachineConfig{}))
+}
@@ -0,0 +1,63 @@
+package proxmox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NodeError records why the VMs of a single PVE node could not be listed.
+type NodeError struct {
+	Node string
+	Err  error
+}
+
+func (e NodeError) Error() string {
+	return fmt.Sprintf("node %s: %v", e.Node, e.Err)
+}
+
+func (e NodeError) Unwrap() error {
+	return e.Err
+}
+
+// PartialResultError is returned together with the VMs that could be listed
+// when one or more PVE nodes failed.
+type PartialResultError struct {
+	Nodes []NodeError
+}
+
+func (e *PartialResultError) Error() string {
+	msgs := make([]string, 0, len(e.Nodes))
+	for _, nodeErr := range e.Nodes {
+		msgs = append(msgs, nodeErr.Error())
+	}
+
+	return fmt.Sprintf("partial VM inventory, %d node(s) failed: %s", len(e.Nodes), strings.Join(msgs, "; "))
+}
+
+func (e *PartialResultError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Nodes))
+	for _, nodeErr := range e.Nodes {
+		errs = append(errs, nodeErr)
+	}
+
+	return errs
+}
+
+// FailedNodes returns the names of the nodes that could not be listed.
+func (e *PartialResultError) FailedNodes() []string {
+	names := make([]string, 0, len(e.Nodes))
+	for _, nodeErr := range e.Nodes {
+		names = append(names, nodeErr.Node)
+	}
+
+	return names
+}
+
+// partialResult collects per-node errors; it returns nil when no node failed.
+func partialResult(nodeErrs []NodeError) error {
+	if len(nodeErrs) == 0 {
+		return nil
+	}
+
+	return &PartialResultError{Nodes: nodeErrs}
+}
@@ -0,0 +1,56 @@
+package proxmox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProviderIDPrefix starts the spec.providerID of Kubernetes nodes running on
+// Proxmox VMs.
+const ProviderIDPrefix = "proxmox://"
+
+// ProviderID identifies the VM of a node by its spec.providerID. Either
+// VMID, optionally qualified by Cluster, or UUID is set.
+type ProviderID struct {
+	Cluster string
+	VMID    int
+	UUID    string
+}
+
+// ParseProviderID parses the providerID formats in common use for Proxmox:
+// "proxmox://<cluster>/<vmid>" as set by the Proxmox cloud controller
+// manager, and "proxmox://<uuid>" as set by the Cluster API provider.
+func ParseProviderID(providerID string) (ProviderID, error) {
+	rest, ok := strings.CutPrefix(providerID, ProviderIDPrefix)
+	if !ok {
+		return ProviderID{}, fmt.Errorf("providerID %q does not start with %s", providerID, ProviderIDPrefix)
+	}
+
+	cluster, rawID, ok := strings.Cut(rest, "/")
+	if !ok {
+		uuid, err := NormalizeUUID(rest)
+		if err != nil {
+			return ProviderID{}, fmt.Errorf("invalid providerID %q: %w", providerID, err)
+		}
+		return ProviderID{UUID: uuid}, nil
+	}
+
+	vmid, err := strconv.Atoi(rawID)
+	if err != nil || vmid <= 0 {
+		return ProviderID{}, fmt.Errorf("invalid providerID %q: %q is not a VM id", providerID, rawID)
+	}
+	if cluster == "" {
+		return ProviderID{}, fmt.Errorf("invalid providerID %q: missing cluster", providerID)
+	}
+
+	return ProviderID{Cluster: cluster, VMID: vmid}, nil
+}
+
+func (p ProviderID) String() string {
+	if p.UUID != "" {
+		return ProviderIDPrefix + p.UUID
+	}
+
+	return fmt.Sprintf("%s%s/%d", ProviderIDPrefix, p.Cluster, p.VMID)
+}
@@ -0,0 +1,43 @@
+package proxmox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProviderID(t *testing.T) {
+	tests := []struct {
+		providerID string
+		want       ProviderID
+		wantErr    bool
+	}{
+		{providerID: "proxmox://east/100", want: ProviderID{Cluster: "east", VMID: 100}},
+		{providerID: "proxmox://" + testUUID(1), want: ProviderID{UUID: testUUID(1)}},
+		{providerID: "proxmox://6F1D2C3B-594A-4E8F-9B7A-0C1D2E3F4A5B", want: ProviderID{UUID: "6f1d2c3b-594a-4e8f-9b7a-0c1d2e3f4a5b"}},
+		{providerID: "proxmox:///100", wantErr: true},
+		{providerID: "proxmox://east/0", wantErr: true},
+		{providerID: "proxmox://east/vm-100", wantErr: true},
+		{providerID: "proxmox://east/pve-1/100", wantErr: true},
+		{providerID: "proxmox://not-a-uuid", wantErr: true},
+		{providerID: "aws:///eu-west-1a/i-0123456789", wantErr: true},
+		{providerID: "", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.providerID, func(t *testing.T) {
+			got, err := ParseProviderID(tc.providerID)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+
+			roundTrip, err := ParseProviderID(got.String())
+			require.NoError(t, err)
+			assert.Equal(t, got, roundTrip)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package proxmox
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// throttledThreshold is the wait above which a request counts as throttled.
+const throttledThreshold = time.Millisecond
+
+// RateLimitConfig configures a token bucket for Proxmox API requests.
+type RateLimitConfig struct {
+	// QPS is the sustained number of API requests per second. Zero disables the limit.
+	QPS float64 `json:"qps"`
+	// Burst is the number of requests that may be made at once. It defaults
+	// to QPS rounded up.
+	Burst int `json:"burst"`
+}
+
+func (c RateLimitConfig) validate() error {
+	if c.QPS < 0 || c.Burst < 0 {
+		return errors.New("rateLimit qps and burst must not be negative")
+	}
+
+	return nil
+}
+
+// newLimiter returns a token bucket for the config, or nil when it is disabled.
+func (c RateLimitConfig) newLimiter() *rate.Limiter {
+	if c.QPS <= 0 {
+		return nil
+	}
+
+	burst := c.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(c.QPS))
+	}
+
+	return rate.NewLimiter(rate.Limit(c.QPS), burst)
+}
+
+// rateLimitTransport delays requests until both the cluster wide and the
+// host's token bucket allow them. Every request, including logins and health
+// checks, takes a token.
+type rateLimitTransport struct {
+	next     http.RoundTripper
+	limiters []*rate.Limiter
+	cluster  string
+	host     string
+}
+
+// newRateLimitTransport returns next unchanged when no limiter is set.
+func newRateLimitTransport(next http.RoundTripper, cluster, host string, limiters ...*rate.Limiter) http.RoundTripper {
+	t := &rateLimitTransport{next: next, cluster: cluster, host: host}
+	for _, limiter := range limiters {
+		if limiter != nil {
+			t.limiters = append(t.limiters, limiter)
+		}
+	}
+	if len(t.limiters) == 0 {
+		return next
+	}
+
+	return t
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	for _, limiter := range t.limiters {
+		if err := limiter.Wait(req.Context()); err != nil {
+			return nil, fmt.Errorf("rate limit of %s: %w", t.host, err)
+		}
+	}
+
+	waited := time.Since(start)
+	rateLimitWaitSeconds.WithLabelValues(t.cluster, t.host).Observe(waited.Seconds())
+	if waited > throttledThreshold {
+		rateLimitThrottledTotal.WithLabelValues(t.cluster, t.host).Inc()
+	}
+
+	return t.next.RoundTrip(req)
+}
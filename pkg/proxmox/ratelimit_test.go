@@ -0,0 +1,48 @@
+package proxmox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitTransport(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+
+	pool, err := NewClientPool(&ClusterConfig{
+		Name:      "rate-limited",
+		TokenID:   "test@pve!test",
+		Secret:    "secret",
+		RateLimit: RateLimitConfig{QPS: 100, Burst: 100},
+		Hosts: []HostConfig{{
+			URL:       f.URL,
+			RateLimit: RateLimitConfig{QPS: 20, Burst: 1},
+		}},
+	})
+	require.NoError(t, err)
+
+	client, err := pool.getClient(t.Context())
+	require.NoError(t, err)
+
+	start := time.Now()
+	for range 5 {
+		_, err := client.Version(t.Context())
+		require.NoError(t, err)
+	}
+
+	// The stricter host limit applies: one request at once, then one every 50ms.
+	assert.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+	assert.Equal(t, float64(4), testutil.ToFloat64(rateLimitThrottledTotal.WithLabelValues("rate-limited", f.URL)))
+}
+
+func TestRateLimitConfig_Disabled(t *testing.T) {
+	assert.Nil(t, RateLimitConfig{}.newLimiter())
+
+	limiter := RateLimitConfig{QPS: 2.5}.newLimiter()
+	require.NotNil(t, limiter)
+	assert.Equal(t, 3, limiter.Burst())
+}
@@ -0,0 +1,87 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 200 * time.Millisecond
+	defaultRetryMaxBackoff     = 5 * time.Second
+)
+
+type RetryConfig struct {
+	// MaxAttempts is the number of tries of a call, including the first one.
+	// Set it to 1 to disable retries.
+	MaxAttempts int `json:"maxAttempts"`
+	// InitialBackoff is the delay before the first retry; it doubles with
+	// every further retry up to MaxBackoff.
+	InitialBackoff Duration `json:"initialBackoff"`
+	MaxBackoff     Duration `json:"maxBackoff"`
+}
+
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultRetryMaxAttempts
+	}
+	c.InitialBackoff.Duration = durationOrDefault(c.InitialBackoff, defaultRetryInitialBackoff)
+	c.MaxBackoff.Duration = durationOrDefault(c.MaxBackoff, defaultRetryMaxBackoff)
+
+	return c
+}
+
+// do calls fn until it succeeds, fails with an error that is not transient,
+// or MaxAttempts is reached. It gives up early when ctx is done or its
+// deadline would pass before the next attempt. fn must be idempotent.
+func (c RetryConfig) do(ctx context.Context, op string, fn func() error) error {
+	backoff := c.InitialBackoff.Duration
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= c.MaxAttempts || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		// Equal jitter: wait at least half of the backoff.
+		delay := backoff/2 + rand.N(backoff/2+1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		slog.Debug("Retrying Proxmox call", "op", op, "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		backoff = min(2*backoff, c.MaxBackoff.Duration)
+	}
+}
+
+// retryable reports whether err is transient: the host or node could not be
+// reached, the request timed out, or Proxmox answered with a server error.
+func retryable(err error) bool {
+	var partial *PartialResultError
+	if errors.As(err, &partial) {
+		return false
+	}
+
+	var proxmoxErr *Error
+	if !errors.As(classify(err), &proxmoxErr) {
+		return false
+	}
+
+	switch proxmoxErr.Kind {
+	case ErrorUnreachable, ErrorTimeout:
+		return true
+	case ErrorUnknown:
+		return proxmoxErr.StatusCode >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
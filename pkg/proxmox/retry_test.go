@@ -0,0 +1,88 @@
+package proxmox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryConfig_Do(t *testing.T) {
+	retry := RetryConfig{
+		MaxAttempts:    3,
+		InitialBackoff: Duration{Duration: time.Millisecond},
+	}.withDefaults()
+	unreachable := &Error{Kind: ErrorUnreachable, Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "success", errs: []error{nil}, wantCalls: 1},
+		{name: "recovers from transient failures", errs: []error{unreachable, unreachable, nil}, wantCalls: 3},
+		{name: "gives up after max attempts", errs: []error{unreachable, unreachable, unreachable, nil}, wantCalls: 3, wantErr: unreachable},
+		{
+			name:      "server errors are transient",
+			errs:      []error{&Error{Kind: ErrorUnknown, StatusCode: 500, Err: errors.New("got timeout")}, nil},
+			wantCalls: 2,
+		},
+		{
+			name:      "permanent failures are not retried",
+			errs:      []error{&Error{Kind: ErrorNotFound, Err: errors.New("no such VM")}, nil},
+			wantCalls: 1,
+			wantErr:   &Error{Kind: ErrorNotFound, Err: errors.New("no such VM")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			err := retry.do(t.Context(), "test", func() error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			})
+
+			assert.Equal(t, tc.wantCalls, calls)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestRetryConfig_DoRespectsDeadline(t *testing.T) {
+	retry := RetryConfig{InitialBackoff: Duration{Duration: time.Second}}.withDefaults()
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	err := retry.do(ctx, "test", func() error {
+		calls++
+		return &Error{Kind: ErrorUnreachable, Err: errors.New("connection refused")}
+	})
+
+	require.Error(t, err)
+	assert.Equal(t, 1, calls, "the backoff would exceed the deadline")
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestClientPool_RetriesTransientFailures(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+	f.unavailable["GET /cluster/resources"] = 1
+	f.unavailable["POST /nodes/pve-1/qemu/100/config"] = 2
+	pool := newTestClientPool(t, f)
+
+	vms, err := pool.listVMs(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, vms, 1)
+	assert.Equal(t, int64(2), f.requestCount("/cluster/resources"))
+
+	require.NoError(t, pool.UpdateVMName(t.Context(), "pve-1", 100, "renamed"))
+	assert.Equal(t, int64(3), f.methodCount("POST", "/nodes/pve-1/qemu/100/config"))
+}
@@ -0,0 +1,252 @@
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Proxmox tickets are valid for two hours; they are renewed well before that.
+	ticketRenewAfter = 90 * time.Minute
+	// loginRetryInterval is how long a rejected login is remembered before
+	// the credentials are tried again.
+	loginRetryInterval = time.Minute
+)
+
+// InvalidCredentialsError is returned when Proxmox rejects the configured
+// username and password. Retrying will not succeed until the configuration
+// is fixed.
+type InvalidCredentialsError struct {
+	Host     string
+	Username string
+}
+
+func (e *InvalidCredentialsError) Error() string {
+	return fmt.Sprintf("Proxmox rejected credentials of user %s on %s", e.Username, e.Host)
+}
+
+type ticket struct {
+	value      string
+	csrfToken  string
+	obtainedAt time.Time
+}
+
+// sessionTransport authenticates requests with a Proxmox session ticket. It
+// logs in on first use, renews the ticket before it expires and logs in again
+// when Proxmox answers with 401. Logins are shared by concurrent requests.
+type sessionTransport struct {
+	next     http.RoundTripper
+	host     string
+	loginURL string
+	username string
+	password string
+	// totpKey answers the TFA challenge when the user has TOTP enabled.
+	totpKey []byte
+
+	mu            sync.Mutex
+	ticket        *ticket
+	loginFailedAt time.Time
+}
+
+func newSessionTransport(next http.RoundTripper, baseURL, username, password string, totpKey []byte) *sessionTransport {
+	return &sessionTransport{
+		next:     next,
+		host:     baseURL,
+		loginURL: strings.TrimSuffix(baseURL, "/") + "/access/ticket",
+		username: username,
+		password: password,
+		totpKey:  totpKey,
+	}
+}
+
+func (t *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	current, err := t.currentTicket(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.next.RoundTrip(withTicket(req, current))
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	// The ticket was rejected before it expired, e.g. because pveproxy was
+	// restarted with a new key. Log in again and retry the request once.
+	if req.Body != nil && req.GetBody == nil {
+		return res, nil
+	}
+	_ = res.Body.Close()
+
+	slog.Info("Proxmox rejected session ticket, logging in again", "host", t.host, "user", t.username)
+	current, err = t.renew(req.Context(), current)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.next.RoundTrip(withTicket(retry, current))
+}
+
+// currentTicket returns a valid ticket, logging in or renewing as needed.
+func (t *sessionTransport) currentTicket(ctx context.Context) (*ticket, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ticket != nil && time.Since(t.ticket.obtainedAt) < ticketRenewAfter {
+		return t.ticket, nil
+	}
+
+	return t.loginLocked(ctx)
+}
+
+// renew replaces stale unless another request already did so.
+func (t *sessionTransport) renew(ctx context.Context, stale *ticket) (*ticket, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ticket != stale {
+		return t.ticket, nil
+	}
+	t.ticket = nil
+
+	return t.loginLocked(ctx)
+}
+
+func (t *sessionTransport) loginLocked(ctx context.Context) (*ticket, error) {
+	if time.Since(t.loginFailedAt) < loginRetryInterval {
+		return nil, &InvalidCredentialsError{Host: t.host, Username: t.username}
+	}
+
+	// A ticket that has not expired yet can be renewed without the password.
+	if t.ticket != nil {
+		renewed, err := t.login(ctx, t.ticket.value)
+		if err == nil {
+			slog.Info("Renewed Proxmox session ticket", "host", t.host, "user", t.username)
+			t.ticket = renewed
+			return renewed, nil
+		}
+		slog.Debug("Failed to renew Proxmox session ticket, logging in with password", "host", t.host, "error", err)
+	}
+
+	fresh, err := t.login(ctx, t.password)
+	if err != nil {
+		t.ticket = nil
+		return nil, err
+	}
+
+	slog.Info("Logged in to Proxmox", "host", t.host, "user", t.username)
+	t.ticket = fresh
+	return fresh, nil
+}
+
+func (t *sessionTransport) login(ctx context.Context, password string) (*ticket, error) {
+	session, err := t.requestTicket(ctx, map[string]string{
+		"username": t.username,
+		"password": password,
+	})
+	if err != nil {
+		var invalid *InvalidCredentialsError
+		if errors.As(err, &invalid) && password == t.password {
+			slog.Error("Proxmox rejected credentials", "host", t.host, "user", t.username, "retryAfter", loginRetryInterval)
+			t.loginFailedAt = time.Now()
+		}
+		return nil, err
+	}
+
+	if session.NeedTFA != 0 {
+		if t.totpKey == nil {
+			return nil, fmt.Errorf("failed to log in to Proxmox: user %s requires two-factor authentication but no TOTP secret is configured", t.username)
+		}
+
+		// The first ticket only grants access to the TFA challenge.
+		session, err = t.requestTicket(ctx, map[string]string{
+			"username":      t.username,
+			"password":      "totp:" + totpCode(t.totpKey, time.Now(), totpDigits),
+			"tfa-challenge": session.Ticket,
+		})
+		if err != nil {
+			var invalid *InvalidCredentialsError
+			if errors.As(err, &invalid) {
+				slog.Error("Proxmox rejected TOTP code", "host", t.host, "user", t.username, "retryAfter", loginRetryInterval)
+				t.loginFailedAt = time.Now()
+			}
+			return nil, err
+		}
+	}
+
+	return &ticket{
+		value:      session.Ticket,
+		csrfToken:  session.CSRFPreventionToken,
+		obtainedAt: time.Now(),
+	}, nil
+}
+
+type ticketResponse struct {
+	Ticket              string `json:"ticket"`
+	CSRFPreventionToken string `json:"CSRFPreventionToken"`
+	NeedTFA             int    `json:"NeedTFA"`
+}
+
+func (t *sessionTransport) requestTicket(ctx context.Context, params map[string]string) (*ticketResponse, error) {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.loginURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to log in to Proxmox: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return nil, &InvalidCredentialsError{Host: t.host, Username: t.username}
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to log in to Proxmox: %s", res.Status)
+	}
+
+	var session struct {
+		Data ticketResponse `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&session); err != nil {
+		return nil, fmt.Errorf("failed to decode Proxmox login response: %w", err)
+	}
+	if session.Data.Ticket == "" {
+		return nil, fmt.Errorf("failed to log in to Proxmox: response contains no ticket")
+	}
+
+	return &session.Data, nil
+}
+
+func withTicket(req *http.Request, t *ticket) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Cookie", "PVEAuthCookie="+t.value)
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		req.Header.Set("CSRFPreventionToken", t.csrfToken)
+	}
+
+	return req
+}
@@ -0,0 +1,105 @@
+package proxmox
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPasswordClientPool(t *testing.T, f *fakeProxmox, password string) (*ClientPool, *sessionTransport) {
+	t.Helper()
+
+	pool, err := NewClientPool(&ClusterConfig{
+		Name:     "test",
+		HostURLs: []string{f.URL},
+		Username: "root@pam",
+		Password: password,
+	})
+	require.NoError(t, err)
+
+	// Swap in a transport the test can inspect.
+	session := newSessionTransport(http.DefaultTransport, f.URL, "root@pam", password, nil)
+	pool.hosts[0].client = proxmox.NewClient(f.URL, proxmox.WithHTTPClient(&http.Client{Transport: session}))
+
+	return pool, session
+}
+
+func TestSessionTransport_Login(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.password = "secret"
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+	pool, session := newPasswordClientPool(t, f, "secret")
+
+	_, err := pool.GetVMs(t.Context())
+	require.NoError(t, err)
+	require.NoError(t, pool.UpdateVMName(t.Context(), "pve-1", 100, "renamed"))
+	assert.Equal(t, int64(1), f.methodCount("POST", "/access/ticket"), "the ticket is reused")
+
+	f.expireTickets()
+	_, err = pool.GetVMs(t.Context())
+	require.NoError(t, err, "a rejected ticket is replaced transparently")
+	assert.Equal(t, int64(2), f.methodCount("POST", "/access/ticket"))
+
+	// Tickets close to expiry are renewed with the ticket itself.
+	session.ticket.obtainedAt = time.Now().Add(-ticketRenewAfter)
+	previous := session.ticket.value
+	_, err = pool.GetVMs(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), f.methodCount("POST", "/access/ticket"))
+	assert.NotEqual(t, previous, session.ticket.value)
+}
+
+func TestSessionTransport_InvalidCredentials(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.password = "secret"
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+	pool, err := NewClientPool(&ClusterConfig{
+		HostURLs: []string{f.URL},
+		Username: "root@pam",
+		Password: "wrong",
+	})
+	require.NoError(t, err)
+
+	_, err = pool.GetVMs(t.Context())
+	var invalid *InvalidCredentialsError
+	require.ErrorAs(t, err, &invalid)
+	assert.Equal(t, "root@pam", invalid.Username)
+
+	_, err = pool.GetVMs(t.Context())
+	require.ErrorAs(t, err, &invalid)
+	assert.Equal(t, int64(1), f.methodCount("POST", "/access/ticket"), "rejected credentials are not retried immediately")
+}
+
+func TestSessionTransport_TOTP(t *testing.T) {
+	f := newFakeProxmox(t)
+	f.password = "secret"
+	f.totpKey = []byte("12345678901234567890")
+	f.addVM("pve-1", fakeVM{ID: 100, Name: "vm-100", SMBIOS: "uuid=" + testUUID(100)})
+
+	pool, err := NewClientPool(&ClusterConfig{
+		HostURLs:   []string{f.URL},
+		Username:   "root@pam",
+		Password:   "secret",
+		TOTPSecret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+	})
+	require.NoError(t, err)
+
+	vms, err := pool.GetVMs(t.Context())
+	require.NoError(t, err)
+	assert.Len(t, vms, 1)
+	assert.Equal(t, int64(2), f.methodCount("POST", "/access/ticket"), "password and TOTP step")
+
+	pool, err = NewClientPool(&ClusterConfig{
+		HostURLs: []string{f.URL},
+		Username: "root@pam",
+		Password: "secret",
+	})
+	require.NoError(t, err)
+
+	_, err = pool.GetVMs(t.Context())
+	assert.ErrorContains(t, err, "no TOTP secret is configured")
+}
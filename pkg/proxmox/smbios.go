@@ -0,0 +1,163 @@
+package proxmox
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SMBIOS holds the SMBIOS type 1 settings of a VM, from its smbios1 config
+// value, e.g. "uuid=...,manufacturer=QkhZVkU=,base64=1".
+type SMBIOS struct {
+	// UUID is normalized to the lower case 8-4-4-4-12 form, or empty.
+	UUID         string
+	Manufacturer string
+	Product      string
+	Version      string
+	Serial       string
+	SKU          string
+	Family       string
+}
+
+// ParseSMBIOS parses a smbios1 value. With base64=1 all fields except the
+// UUID are base64 encoded. Unknown keys are ignored. Malformed entries and
+// fields are reported in the error but do not stop the others from being
+// parsed, so a valid UUID is returned even when another field is invalid.
+func ParseSMBIOS(value string) (SMBIOS, error) {
+	var errs []error
+	fields := make(map[string]string)
+	for entry := range strings.SplitSeq(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, fieldValue, ok := strings.Cut(entry, "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid smbios1 entry %q: missing '='", entry))
+			continue
+		}
+		fields[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(fieldValue)
+	}
+
+	encoded := fields["base64"] == "1"
+	var smbios SMBIOS
+	for _, field := range []struct {
+		key    string
+		target *string
+	}{
+		{"manufacturer", &smbios.Manufacturer},
+		{"product", &smbios.Product},
+		{"version", &smbios.Version},
+		{"serial", &smbios.Serial},
+		{"sku", &smbios.SKU},
+		{"family", &smbios.Family},
+	} {
+		fieldValue := fields[field.key]
+		if encoded && fieldValue != "" {
+			decoded, err := base64.StdEncoding.DecodeString(fieldValue)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid smbios1 %s: %w", field.key, err))
+				continue
+			}
+			fieldValue = string(decoded)
+		}
+		*field.target = fieldValue
+	}
+
+	if raw := fields["uuid"]; raw != "" {
+		uuid, err := NormalizeUUID(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid smbios1 uuid: %w", err))
+		}
+		smbios.UUID = uuid
+	}
+
+	return smbios, errors.Join(errs...)
+}
+
+// String formats s as a base64 encoded smbios1 value.
+func (s SMBIOS) String() string {
+	var entries []string
+	if s.UUID != "" {
+		entries = append(entries, "uuid="+s.UUID)
+	}
+	for _, field := range []struct{ key, value string }{
+		{"manufacturer", s.Manufacturer},
+		{"product", s.Product},
+		{"version", s.Version},
+		{"serial", s.Serial},
+		{"sku", s.SKU},
+		{"family", s.Family},
+	} {
+		if field.value != "" {
+			entries = append(entries, field.key+"="+base64.StdEncoding.EncodeToString([]byte(field.value)))
+		}
+	}
+	entries = append(entries, "base64=1")
+
+	return strings.Join(entries, ",")
+}
+
+// NormalizeUUID returns uuid in the lower case 8-4-4-4-12 form. Surrounding
+// whitespace and braces, upper case and missing dashes are accepted.
+func NormalizeUUID(uuid string) (string, error) {
+	trimmed := strings.TrimSpace(uuid)
+	trimmed = strings.TrimSuffix(strings.TrimPrefix(trimmed, "{"), "}")
+
+	var raw [16]byte
+	digits := strings.ReplaceAll(trimmed, "-", "")
+	if len(digits) != hex.EncodedLen(len(raw)) {
+		return "", fmt.Errorf("%q is not a UUID", uuid)
+	}
+	if _, err := hex.Decode(raw[:], []byte(digits)); err != nil {
+		return "", fmt.Errorf("%q is not a UUID", uuid)
+	}
+	if strings.Contains(trimmed, "-") && !hasUUIDDashes(trimmed) {
+		return "", fmt.Errorf("%q is not a UUID", uuid)
+	}
+
+	return formatUUID(raw), nil
+}
+
+// MixedEndianUUID returns uuid with the byte order of its first three groups
+// reversed. SMBIOS stores those groups little-endian, and some firmware and
+// guest OS combinations disagree with Proxmox on whether to swap them.
+func MixedEndianUUID(uuid string) (string, error) {
+	normalized, err := NormalizeUUID(uuid)
+	if err != nil {
+		return "", err
+	}
+
+	var raw [16]byte
+	if _, err := hex.Decode(raw[:], []byte(strings.ReplaceAll(normalized, "-", ""))); err != nil {
+		return "", err
+	}
+	slices.Reverse(raw[0:4])
+	slices.Reverse(raw[4:6])
+	slices.Reverse(raw[6:8])
+
+	return formatUUID(raw), nil
+}
+
+func hasUUIDDashes(uuid string) bool {
+	if len(uuid) != 36 {
+		return false
+	}
+	for i, c := range uuid {
+		isDashPosition := i == 8 || i == 13 || i == 18 || i == 23
+		if isDashPosition != (c == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func formatUUID(raw [16]byte) string {
+	s := hex.EncodeToString(raw[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
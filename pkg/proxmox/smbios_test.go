@@ -0,0 +1,166 @@
+package proxmox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSMBIOS(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    SMBIOS
+		wantErr string
+	}{
+		{
+			name:  "uuid only",
+			value: "uuid=6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b",
+			want:  SMBIOS{UUID: "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b"},
+		},
+		{
+			name:  "upper case uuid and whitespace",
+			value: " uuid = 6F1D2C3B-4A59-4E8F-9B7A-0C1D2E3F4A5B , serial=abc",
+			want:  SMBIOS{UUID: "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b", Serial: "abc"},
+		},
+		{
+			name:  "all fields",
+			value: "uuid=6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b,manufacturer=ACME,product=VM,version=1,serial=S1,sku=K1,family=F1",
+			want: SMBIOS{
+				UUID:         "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b",
+				Manufacturer: "ACME",
+				Product:      "VM",
+				Version:      "1",
+				Serial:       "S1",
+				SKU:          "K1",
+				Family:       "F1",
+			},
+		},
+		{
+			name:  "base64 encoded fields",
+			value: "uuid=6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b,manufacturer=QUNNRSwgSW5jLg==,serial=dXVpZD1ub3QtdGhpcw==,base64=1",
+			want: SMBIOS{
+				UUID:         "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b",
+				Manufacturer: "ACME, Inc.",
+				Serial:       "uuid=not-this",
+			},
+		},
+		{
+			name:  "uuid= inside another value",
+			value: "serial=uuid=11111111-2222-3333-4444-555555555555,uuid=6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b",
+			want: SMBIOS{
+				UUID:   "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b",
+				Serial: "uuid=11111111-2222-3333-4444-555555555555",
+			},
+		},
+		{
+			name:  "empty",
+			value: "",
+			want:  SMBIOS{},
+		},
+		{
+			name:    "invalid uuid",
+			value:   "uuid=not-a-uuid",
+			wantErr: "invalid smbios1 uuid",
+		},
+		{
+			name:    "invalid base64",
+			value:   "uuid=6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b,serial=%%%,base64=1",
+			want:    SMBIOS{UUID: "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b"},
+			wantErr: "invalid smbios1 serial",
+		},
+		{
+			name:    "entry without value",
+			value:   "uuid=6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b,garbage",
+			want:    SMBIOS{UUID: "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b"},
+			wantErr: "missing '='",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseSMBIOS(tc.value)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestNormalizeUUID(t *testing.T) {
+	for _, uuid := range []string{
+		"6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b",
+		"6F1D2C3B-4A59-4E8F-9B7A-0C1D2E3F4A5B",
+		"{6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b}",
+		"6f1d2c3b4a594e8f9b7a0c1d2e3f4a5b",
+		"  6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b\n",
+	} {
+		got, err := NormalizeUUID(uuid)
+		require.NoError(t, err, uuid)
+		assert.Equal(t, "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b", got)
+	}
+
+	for _, uuid := range []string{"", "6f1d2c3b", "6f1d2c3b-4a594e8f-9b7a-0c1d-2e3f4a5b", "zf1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b"} {
+		_, err := NormalizeUUID(uuid)
+		assert.Error(t, err, uuid)
+	}
+}
+
+func TestMixedEndianUUID(t *testing.T) {
+	got, err := MixedEndianUUID("6F1D2C3B-4A59-4E8F-9B7A-0C1D2E3F4A5B")
+	require.NoError(t, err)
+	assert.Equal(t, "3b2c1d6f-594a-8f4e-9b7a-0c1d2e3f4a5b", got)
+
+	back, err := MixedEndianUUID(got)
+	require.NoError(t, err)
+	assert.Equal(t, "6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b", back)
+
+	_, err = MixedEndianUUID("not-a-uuid")
+	assert.Error(t, err)
+}
+
+func FuzzParseSMBIOS(f *testing.F) {
+	f.Add("uuid=6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b")
+	f.Add("uuid=6F1D2C3B-4A59-4E8F-9B7A-0C1D2E3F4A5B,manufacturer=QUNNRQ==,base64=1")
+	f.Add("serial=uuid=x,uuid=6f1d2c3b4a594e8f9b7a0c1d2e3f4a5b,sku=,family=f")
+	f.Add(",,=,base64=1")
+
+	f.Fuzz(func(t *testing.T, value string) {
+		smbios, err := ParseSMBIOS(value)
+		if err != nil {
+			return
+		}
+
+		if smbios.UUID != "" {
+			normalized, err := NormalizeUUID(smbios.UUID)
+			require.NoError(t, err)
+			assert.Equal(t, normalized, smbios.UUID, "UUIDs are normalized")
+		}
+
+		// Formatting and parsing again yields the same settings.
+		reparsed, err := ParseSMBIOS(smbios.String())
+		require.NoError(t, err)
+		assert.Equal(t, smbios, reparsed)
+	})
+}
+
+func FuzzNormalizeUUID(f *testing.F) {
+	f.Add("6f1d2c3b-4a59-4e8f-9b7a-0c1d2e3f4a5b")
+	f.Add("{6F1D2C3B4A594E8F9B7A0C1D2E3F4A5B}")
+
+	f.Fuzz(func(t *testing.T, uuid string) {
+		normalized, err := NormalizeUUID(uuid)
+		if err != nil {
+			return
+		}
+
+		again, err := NormalizeUUID(normalized)
+		require.NoError(t, err)
+		assert.Equal(t, normalized, again)
+		assert.Len(t, normalized, 36)
+	})
+}
@@ -0,0 +1,116 @@
+package proxmox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// hostTLSConfig returns nil when the default TLS settings apply.
+func hostTLSConfig(host HostConfig) (*tls.Config, error) {
+	insecure := host.Insecure != nil && *host.Insecure
+	if !insecure && host.CABundle == "" && host.CABundleFile == "" && host.ServerName == "" && host.Fingerprint == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: host.ServerName,
+		// #nosec G402
+		InsecureSkipVerify: insecure,
+	}
+
+	bundle, err := caBundle(host)
+	if err != nil {
+		return nil, err
+	}
+	if bundle != nil {
+		pool, err := certPoolFromPEM(bundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if host.Fingerprint != "" {
+		pin, err := parseFingerprint(host.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+
+		if bundle == nil {
+			// The pin is the only check, the chain of a self-signed certificate cannot be verified.
+			// #nosec G402
+			tlsConfig.InsecureSkipVerify = true
+		}
+		tlsConfig.VerifyPeerCertificate = verifyFingerprint(pin)
+	}
+
+	return tlsConfig, nil
+}
+
+func caBundle(host HostConfig) ([]byte, error) {
+	if host.CABundleFile != "" {
+		bundle, err := os.ReadFile(host.CABundleFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle file: %w", err)
+		}
+
+		return bundle, nil
+	}
+
+	if host.CABundle != "" {
+		return []byte(host.CABundle), nil
+	}
+
+	return nil, nil
+}
+
+func certPoolFromPEM(bundle []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("CA bundle does not contain any valid PEM certificate")
+	}
+
+	return pool, nil
+}
+
+// parseFingerprint accepts a SHA-256 fingerprint in hex, with or without colons.
+func parseFingerprint(fingerprint string) ([]byte, error) {
+	raw := strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", "")
+	pin, err := hex.DecodeString(raw)
+	if err != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("fingerprint %q is not a SHA-256 fingerprint", fingerprint)
+	}
+
+	return pin, nil
+}
+
+func verifyFingerprint(pin []byte) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server presented no certificate")
+		}
+
+		sum := sha256.Sum256(rawCerts[0])
+		if !bytes.Equal(sum[:], pin) {
+			return fmt.Errorf("server certificate fingerprint %s does not match the pinned fingerprint", formatFingerprint(sum[:]))
+		}
+
+		return nil
+	}
+}
+
+func formatFingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,86 @@
+package proxmox
+
+import (
+	"crypto/sha256"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	cert := server.Certificate()
+	sum := sha256.Sum256(cert.Raw)
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	bundleFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bundleFile, bundle, 0o600))
+
+	tests := []struct {
+		name    string
+		host    HostConfig
+		wantErr string
+	}{
+		{
+			name:    "default verification rejects self-signed certificate",
+			host:    HostConfig{},
+			wantErr: "certificate",
+		},
+		{
+			name: "inline CA bundle",
+			host: HostConfig{CABundle: string(bundle)},
+		},
+		{
+			name: "CA bundle file",
+			host: HostConfig{CABundleFile: bundleFile},
+		},
+		{
+			name: "pinned fingerprint without CA bundle",
+			host: HostConfig{Fingerprint: formatFingerprint(sum[:])},
+		},
+		{
+			name: "pinned fingerprint together with CA bundle",
+			host: HostConfig{CABundle: string(bundle), Fingerprint: formatFingerprint(sum[:])},
+		},
+		{
+			name:    "mismatching fingerprint",
+			host:    HostConfig{Fingerprint: formatFingerprint(make([]byte, sha256.Size))},
+			wantErr: "does not match the pinned fingerprint",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig, err := hostTLSConfig(tt.host)
+			require.NoError(t, err)
+
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = tlsConfig
+			res, err := (&http.Client{Transport: transport}).Get(server.URL)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, res.Body.Close())
+		})
+	}
+}
+
+func TestHostTLSConfig_InvalidSettings(t *testing.T) {
+	_, err := hostTLSConfig(HostConfig{CABundleFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.ErrorContains(t, err, "failed to read CA bundle file")
+
+	_, err = hostTLSConfig(HostConfig{CABundle: "not a certificate"})
+	assert.ErrorContains(t, err, "CA bundle does not contain any valid PEM certificate")
+
+	_, err = hostTLSConfig(HostConfig{Fingerprint: "AB:CD"})
+	assert.ErrorContains(t, err, "is not a SHA-256 fingerprint")
+}
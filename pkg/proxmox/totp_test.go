@@ -0,0 +1,44 @@
+package proxmox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Test vectors from RFC 6238 appendix B (SHA1).
+func TestTOTPCode_RFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, totpCode(key, time.Unix(tc.unix, 0), 8), "T=%d", tc.unix)
+		assert.Equal(t, tc.want[2:], totpCode(key, time.Unix(tc.unix, 0), totpDigits), "T=%d", tc.unix)
+	}
+}
+
+func TestDecodeTOTPSecret(t *testing.T) {
+	// Base32 of the RFC 6238 key, as Proxmox displays it.
+	key, err := decodeTOTPSecret("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("12345678901234567890"), key)
+
+	_, err = decodeTOTPSecret("not base32!")
+	assert.Error(t, err)
+
+	_, err = decodeTOTPSecret("")
+	assert.Error(t, err)
+}